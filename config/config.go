@@ -1,6 +1,8 @@
 package config
 
 import (
+	"encoding/json"
+	"fmt"
 	"os"
 	"strconv"
 	"strings"
@@ -18,20 +20,87 @@ type Config struct {
 	EmbeddingProvider  string
 	EmbeddingModel     string
 	EmbeddingDimension int
+	EmbeddingFallback  string // "none" or "mock"
+
+	// Model registry
+	ModelsConfigPath string
+	Models           []ModelConfig
+
+	// Provider chain
+	EmbeddingProviderChain        []string
+	CircuitBreakerThreshold       int
+	CircuitBreakerCooldownSeconds int
 
 	// OpenAI
 	OpenAIAPIKey         string
 	OpenAIEmbeddingModel string
+	OpenAIBaseURL        string
+	OpenAISendDimensions bool
+	OpenAIBatchSize      int
 
 	// Ollama
-	OllamaURL   string
-	OllamaModel string
+	OllamaURL       string
+	OllamaModel     string
+	OllamaBatchSize int
+
+	// Azure OpenAI
+	AzureOpenAIEndpoints      []string
+	AzureOpenAIAPIKeys        []string
+	AzureOpenAIDeployment     string
+	AzureOpenAIAPIVersion     string
+	AzureOpenAISendDimensions bool
+	AzureOpenAIBatchSize      int
+
+	// Cohere
+	CohereAPIKey    string
+	CohereBaseURL   string
+	CohereModel     string
+	CohereBatchSize int
+
+	// Voyage AI
+	VoyageAPIKey    string
+	VoyageBaseURL   string
+	VoyageModel     string
+	VoyageBatchSize int
+
+	// Hugging Face Text Embeddings Inference
+	TEIURL                 string
+	TEIAPIToken            string
+	TEIModel               string
+	TEIBatchSize           int
+	TEINormalize           bool
+	TEITruncate            bool
+	TEITruncationDirection string
+
+	// Offline provider
+	OfflineIDFPath string
+
+	// Tokenizer used for token counts and limits
+	Tokenizer          string // "whitespace" or "bpe"
+	TokenizerVocabPath string
+
+	// Embedding cache
+	EmbeddingCacheSize int
+	EmbeddingCacheDisk bool
+
+	// Upstream concurrency, applied per provider
+	ProviderMaxConcurrency     int
+	ProviderRateLimitPerSecond int
 
 	// Limits
-	MaxBatchSize     int
-	MaxChunkSize     int
-	DefaultChunkSize int
-	SyncFileLimitMB  int
+	MaxBatchSize       int
+	MaxChunkSize       int
+	DefaultChunkSize   int
+	DefaultChunkTokens int
+	SyncFileLimitMB    int
+
+	// Semantic chunking
+	SemanticBreakpointPercentile int // distances above this percentile start a new chunk
+	SemanticWindowSentences      int // sentences on each side of a sentence embedded with it
+
+	// Timeouts
+	RequestTimeoutSeconds int
+	JobTimeoutSeconds     int
 
 	// Rate Limiting
 	RateLimitPerSecond int
@@ -57,17 +126,71 @@ func Load() (*Config, error) {
 		EmbeddingProvider:  getEnv("EMBEDDING_PROVIDER", "mock"),
 		EmbeddingModel:     getEnv("EMBEDDING_MODEL", "embed-large-512"),
 		EmbeddingDimension: getEnvInt("EMBEDDING_DIMENSION", 512),
+		EmbeddingFallback:  getEnv("EMBEDDING_FALLBACK", "none"),
+
+		ModelsConfigPath: getEnv("MODELS_CONFIG_PATH", ""),
+
+		EmbeddingProviderChain:        getEnvList("EMBEDDING_PROVIDER_CHAIN"),
+		CircuitBreakerThreshold:       getEnvInt("CIRCUIT_BREAKER_THRESHOLD", 5),
+		CircuitBreakerCooldownSeconds: getEnvInt("CIRCUIT_BREAKER_COOLDOWN_SECONDS", 30),
 
 		OpenAIAPIKey:         getEnv("OPENAI_API_KEY", ""),
 		OpenAIEmbeddingModel: getEnv("OPENAI_EMBEDDING_MODEL", "text-embedding-3-small"),
+		OpenAIBaseURL:        strings.TrimRight(getEnv("OPENAI_BASE_URL", "https://api.openai.com/v1"), "/"),
+		OpenAISendDimensions: getEnvBool("OPENAI_SEND_DIMENSIONS", true),
+		OpenAIBatchSize:      getEnvInt("OPENAI_BATCH_SIZE", 2048),
+
+		OllamaURL:       getEnv("OLLAMA_URL", "http://localhost:11434"),
+		OllamaModel:     getEnv("OLLAMA_MODEL", "nomic-embed-text"),
+		OllamaBatchSize: getEnvInt("OLLAMA_BATCH_SIZE", 64),
+
+		AzureOpenAIEndpoints:      getEnvList("AZURE_OPENAI_ENDPOINTS"),
+		AzureOpenAIAPIKeys:        getEnvList("AZURE_OPENAI_API_KEYS"),
+		AzureOpenAIDeployment:     getEnv("AZURE_OPENAI_DEPLOYMENT", ""),
+		AzureOpenAIAPIVersion:     getEnv("AZURE_OPENAI_API_VERSION", "2024-02-01"),
+		AzureOpenAISendDimensions: getEnvBool("AZURE_OPENAI_SEND_DIMENSIONS", true),
+		AzureOpenAIBatchSize:      getEnvInt("AZURE_OPENAI_BATCH_SIZE", 2048),
+
+		CohereAPIKey:    getEnv("COHERE_API_KEY", ""),
+		CohereBaseURL:   strings.TrimRight(getEnv("COHERE_BASE_URL", "https://api.cohere.com"), "/"),
+		CohereModel:     getEnv("COHERE_MODEL", "embed-english-v3.0"),
+		CohereBatchSize: getEnvInt("COHERE_BATCH_SIZE", 96),
+
+		VoyageAPIKey:    getEnv("VOYAGE_API_KEY", ""),
+		VoyageBaseURL:   strings.TrimRight(getEnv("VOYAGE_BASE_URL", "https://api.voyageai.com/v1"), "/"),
+		VoyageModel:     getEnv("VOYAGE_MODEL", "voyage-3"),
+		VoyageBatchSize: getEnvInt("VOYAGE_BATCH_SIZE", 128),
+
+		TEIURL:                 strings.TrimRight(getEnv("TEI_URL", "http://localhost:8081"), "/"),
+		TEIAPIToken:            getEnv("TEI_API_TOKEN", ""),
+		TEIModel:               getEnv("TEI_MODEL", "text-embeddings-inference"),
+		TEIBatchSize:           getEnvInt("TEI_BATCH_SIZE", 32),
+		TEINormalize:           getEnvBool("TEI_NORMALIZE", true),
+		TEITruncate:            getEnvBool("TEI_TRUNCATE", false),
+		TEITruncationDirection: getEnv("TEI_TRUNCATION_DIRECTION", "Right"),
+
+		OfflineIDFPath: getEnv("OFFLINE_IDF_PATH", ""),
 
-		OllamaURL:   getEnv("OLLAMA_URL", "http://localhost:11434"),
-		OllamaModel: getEnv("OLLAMA_MODEL", "nomic-embed-text"),
+		Tokenizer:          getEnv("TOKENIZER", "whitespace"),
+		TokenizerVocabPath: getEnv("TOKENIZER_VOCAB_PATH", ""),
 
-		MaxBatchSize:     getEnvInt("MAX_BATCH_SIZE", 100),
-		MaxChunkSize:     getEnvInt("MAX_CHUNK_SIZE", 8000),
-		DefaultChunkSize: getEnvInt("DEFAULT_CHUNK_SIZE", 1000),
-		SyncFileLimitMB:  getEnvInt("SYNC_FILE_LIMIT_MB", 5),
+		EmbeddingCacheSize: getEnvInt("EMBEDDING_CACHE_SIZE", 10000),
+		EmbeddingCacheDisk: getEnvBool("EMBEDDING_CACHE_DISK", false),
+
+		ProviderMaxConcurrency:     getEnvInt("PROVIDER_MAX_CONCURRENCY", 4),
+		ProviderRateLimitPerSecond: getEnvInt("PROVIDER_RATE_LIMIT_PER_SECOND", 0),
+
+		MaxBatchSize:       getEnvInt("MAX_BATCH_SIZE", 100),
+		MaxChunkSize:       getEnvInt("MAX_CHUNK_SIZE", 8000),
+		DefaultChunkSize:   getEnvInt("DEFAULT_CHUNK_SIZE", 1000),
+		DefaultChunkTokens: getEnvInt("DEFAULT_CHUNK_TOKENS", 256),
+		SyncFileLimitMB:    getEnvInt("SYNC_FILE_LIMIT_MB", 5),
+
+		SemanticBreakpointPercentile: getEnvInt("SEMANTIC_BREAKPOINT_PERCENTILE", 95),
+		SemanticWindowSentences:      getEnvInt("SEMANTIC_WINDOW_SENTENCES", 1),
+
+		RequestTimeoutSeconds: getEnvInt("REQUEST_TIMEOUT_SECONDS", 60),
+		JobTimeoutSeconds:     getEnvInt("JOB_TIMEOUT_SECONDS", 1800),
 
 		RateLimitPerSecond: getEnvInt("RATE_LIMIT_PER_SECOND", 10),
 		RateLimitBurst:     getEnvInt("RATE_LIMIT_BURST", 20),
@@ -76,10 +199,77 @@ func Load() (*Config, error) {
 		StoragePath: getEnv("STORAGE_PATH", "./storage"),
 	}
 
+	models, err := loadModels(config)
+	if err != nil {
+		return nil, err
+	}
+	config.Models = models
+
 	AppConfig = config
 	return config, nil
 }
 
+// ModelConfig describes a public embedding model served by the API
+type ModelConfig struct {
+	Name           string            `json:"name"`
+	Provider       string            `json:"provider"`
+	UpstreamModel  string            `json:"upstream_model,omitempty"`
+	Endpoints      []string          `json:"endpoints,omitempty"` // provider endpoints, e.g. Azure regions in failover order
+	APIKeys        []string          `json:"api_keys,omitempty"`  // one key for all endpoints or one per endpoint
+	Dimension      int               `json:"dimension"`
+	MaxInputLength int               `json:"max_input_length,omitempty"`
+	MaxInputTokens int               `json:"max_input_tokens,omitempty"`
+	Normalize      bool              `json:"normalize,omitempty"`
+	InputPrefixes  map[string]string `json:"input_prefixes,omitempty"`  // input_type → prefix, e.g. "query" → "query: "
+	Fallback       []string          `json:"fallback,omitempty"`        // "provider" or "provider:upstream_model"
+	Tokenizer      string            `json:"tokenizer,omitempty"`       // defaults to TOKENIZER
+	TokenizerVocab string            `json:"tokenizer_vocab,omitempty"` // defaults to TOKENIZER_VOCAB_PATH
+}
+
+// loadModels reads the model registry from MODELS_CONFIG_PATH, or derives a
+// single model from the EMBEDDING_* variables when no file is configured
+func loadModels(cfg *Config) ([]ModelConfig, error) {
+	if cfg.ModelsConfigPath == "" {
+		chain := cfg.EmbeddingProviderChain
+		if len(chain) == 0 {
+			chain = []string{cfg.EmbeddingProvider}
+		}
+		return []ModelConfig{{
+			Name:           cfg.EmbeddingModel,
+			Provider:       chain[0],
+			Dimension:      cfg.EmbeddingDimension,
+			MaxInputLength: cfg.MaxChunkSize,
+			Fallback:       chain[1:],
+			Tokenizer:      cfg.Tokenizer,
+			TokenizerVocab: cfg.TokenizerVocabPath,
+		}}, nil
+	}
+
+	data, err := os.ReadFile(cfg.ModelsConfigPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read models config: %w", err)
+	}
+
+	var models []ModelConfig
+	if err := json.Unmarshal(data, &models); err != nil {
+		return nil, fmt.Errorf("failed to parse models config %s: %w", cfg.ModelsConfigPath, err)
+	}
+
+	for i := range models {
+		if models[i].MaxInputLength <= 0 || models[i].MaxInputLength > cfg.MaxChunkSize {
+			models[i].MaxInputLength = cfg.MaxChunkSize
+		}
+		if models[i].Tokenizer == "" {
+			models[i].Tokenizer = cfg.Tokenizer
+		}
+		if models[i].TokenizerVocab == "" {
+			models[i].TokenizerVocab = cfg.TokenizerVocabPath
+		}
+	}
+
+	return models, nil
+}
+
 func getEnv(key, defaultValue string) string {
 	if value := os.Getenv(key); value != "" {
 		return value
@@ -95,3 +285,22 @@ func getEnvInt(key string, defaultValue int) int {
 	}
 	return defaultValue
 }
+
+func getEnvBool(key string, defaultValue bool) bool {
+	if value := os.Getenv(key); value != "" {
+		if boolVal, err := strconv.ParseBool(value); err == nil {
+			return boolVal
+		}
+	}
+	return defaultValue
+}
+
+func getEnvList(key string) []string {
+	var values []string
+	for _, value := range strings.Split(os.Getenv(key), ",") {
+		if value = strings.TrimSpace(value); value != "" {
+			values = append(values, value)
+		}
+	}
+	return values
+}
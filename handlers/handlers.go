@@ -4,14 +4,22 @@ import (
 	"batch-embedding-api/config"
 	"batch-embedding-api/models"
 	"batch-embedding-api/services"
+	"context"
+	"errors"
 	"io"
 	"net/http"
 	"path/filepath"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/gin-gonic/gin"
 )
 
+// statusClientClosedRequest is the non-standard status used when the client
+// disconnects before the response is ready
+const statusClientClosedRequest = 499
+
 // Handler contains all HTTP handlers
 type Handler struct {
 	config           *config.Config
@@ -32,59 +40,43 @@ func NewHandler(cfg *config.Config, embeddingService *services.EmbeddingService,
 
 // Health handles GET /v1/health
 func (h *Handler) Health(c *gin.Context) {
+	providers := h.embeddingService.ProviderHealth()
+
+	// Report degraded when any provider in the chain has tripped its breaker
+	status := "ok"
+	for _, provider := range providers {
+		if provider.State != services.BreakerClosed {
+			status = "degraded"
+			break
+		}
+	}
+
 	c.JSON(http.StatusOK, models.HealthResponse{
-		Status:     "ok",
+		Status:     status,
 		Version:    "1.0.0",
 		QueueDepth: h.jobStore.GetQueueDepth(),
+		Providers:  providers,
+		Cache:      h.embeddingService.CacheHealth(),
 	})
 }
 
 // Embed handles POST /v1/embed - synchronous embedding
 func (h *Handler) Embed(c *gin.Context) {
-	var req models.EmbedRequest
-
-	if err := c.ShouldBindJSON(&req); err != nil {
-		c.JSON(http.StatusBadRequest, models.Error{
-			Code:    "invalid_request",
-			Message: err.Error(),
-		})
+	req, ok := h.bindEmbedRequest(c)
+	if !ok {
 		return
 	}
 
-	// Validate batch size
-	if len(req.Inputs) > h.config.MaxBatchSize {
-		c.JSON(http.StatusRequestEntityTooLarge, models.Error{
-			Code:    "payload_too_large",
-			Message: "Batch size exceeds maximum allowed",
-		})
-		return
-	}
-
-	// Validate chunk size
-	if req.ChunkSize < 0 || req.ChunkSize > h.config.MaxChunkSize {
-		c.JSON(http.StatusBadRequest, models.Error{
-			Code:    "invalid_request",
-			Message: "Invalid chunk_size",
-		})
-		return
-	}
-
-	// Validate truncate strategy
-	if req.TruncateStrategy != "" && req.TruncateStrategy != "truncate" && req.TruncateStrategy != "split" {
-		c.JSON(http.StatusBadRequest, models.Error{
-			Code:    "invalid_request",
-			Message: "truncate_strategy must be 'truncate' or 'split'",
-		})
+	ctx, cancel, ok := h.requestContext(c, req.TimeoutMs)
+	if !ok {
 		return
 	}
+	defer cancel()
 
 	// Generate embeddings
-	resp, err := h.embeddingService.GenerateEmbeddings(&req)
+	resp, err := h.embeddingService.GenerateEmbeddings(ctx, req)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, models.Error{
-			Code:    "internal_error",
-			Message: err.Error(),
-		})
+		respondEmbeddingError(c, err)
 		return
 	}
 
@@ -93,80 +85,58 @@ func (h *Handler) Embed(c *gin.Context) {
 
 // EmbedFile handles POST /v1/embed/file - file upload embedding
 func (h *Handler) EmbedFile(c *gin.Context) {
-	// Get file from form
-	file, header, err := c.Request.FormFile("file")
-	if err != nil {
-		c.JSON(http.StatusBadRequest, models.Error{
-			Code:    "invalid_request",
-			Message: "No file uploaded",
-		})
-		return
-	}
-	defer file.Close()
-
-	// Validate file type
-	ext := strings.ToLower(filepath.Ext(header.Filename))
-	if ext != ".pdf" && ext != ".txt" {
-		c.JSON(http.StatusBadRequest, models.Error{
-			Code:    "invalid_request",
-			Message: "Unsupported file type. Only PDF and TXT files are allowed.",
-		})
+	timeoutMs, _ := strconv.Atoi(c.PostForm("timeout_ms"))
+	ctx, cancel, ok := h.requestContext(c, timeoutMs)
+	if !ok {
 		return
 	}
+	defer cancel()
 
-	// Check file size
-	fileSizeMB := float64(header.Size) / (1024 * 1024)
-	if fileSizeMB > float64(h.config.SyncFileLimitMB) {
-		// Too large for sync processing - create async job
-		// For now, just return an error. Full async would save file first.
-		c.JSON(http.StatusRequestEntityTooLarge, models.Error{
-			Code:    "payload_too_large",
-			Message: "File too large for synchronous processing. Use /v1/jobs for async processing.",
-		})
+	req, ok := h.bindFileRequest(ctx, c)
+	if !ok {
 		return
 	}
 
-	// Read file content
-	content, err := io.ReadAll(file)
+	resp, err := h.embeddingService.GenerateEmbeddings(ctx, req)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, models.Error{
-			Code:    "internal_error",
-			Message: "Failed to read file",
-		})
+		respondEmbeddingError(c, err)
 		return
 	}
 
-	// Extract text from file
-	text, err := h.embeddingService.ExtractTextFromFile(header.Filename, content)
-	if err != nil {
-		c.JSON(http.StatusBadRequest, models.Error{
-			Code:    "invalid_request",
-			Message: err.Error(),
-		})
-		return
-	}
-
-	// Get form parameters
-	model := c.DefaultPostForm("model", h.config.EmbeddingModel)
-	truncateStrategy := c.DefaultPostForm("truncate_strategy", "split")
-	chunkSize := h.config.DefaultChunkSize
-	normalize := c.DefaultPostForm("normalize", "true") == "true"
+	c.JSON(http.StatusOK, resp)
+}
 
-	// Generate embeddings
-	req := &models.EmbedRequest{
-		Model:            model,
-		Inputs:           []models.InputItem{{ID: header.Filename, Text: text}},
-		TruncateStrategy: truncateStrategy,
-		ChunkSize:        chunkSize,
-		Normalize:        normalize,
+// Chunk handles POST /v1/chunk - preview the chunks of a JSON request or an
+// uploaded file without embedding them
+func (h *Handler) Chunk(c *gin.Context) {
+	var (
+		req    *models.EmbedRequest
+		ctx    context.Context
+		cancel context.CancelFunc
+		ok     bool
+	)
+	if strings.HasPrefix(c.ContentType(), "multipart/form-data") {
+		timeoutMs, _ := strconv.Atoi(c.PostForm("timeout_ms"))
+		if ctx, cancel, ok = h.requestContext(c, timeoutMs); !ok {
+			return
+		}
+		defer cancel()
+		if req, ok = h.bindFileRequest(ctx, c); !ok {
+			return
+		}
+	} else {
+		if req, ok = h.bindEmbedRequest(c); !ok {
+			return
+		}
+		if ctx, cancel, ok = h.requestContext(c, req.TimeoutMs); !ok {
+			return
+		}
+		defer cancel()
 	}
 
-	resp, err := h.embeddingService.GenerateEmbeddings(req)
+	resp, err := h.embeddingService.PreviewChunks(ctx, req)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, models.Error{
-			Code:    "internal_error",
-			Message: err.Error(),
-		})
+		respondEmbeddingError(c, err)
 		return
 	}
 
@@ -208,8 +178,38 @@ func (h *Handler) CreateJob(c *gin.Context) {
 		}
 	}
 
+	// Validate model
+	model, ok := h.lookupModel(c, req.Model)
+	if !ok {
+		return
+	}
+
+	if !h.validateChunking(c, model, req.ChunkSize, req.ChunkUnit, req.ChunkOverlap) {
+		return
+	}
+
+	if !validateTruncateStrategy(c, req.TruncateStrategy) {
+		return
+	}
+
+	if !validateSemantic(c, req.MinChunkSize, req.BreakpointPercentile) {
+		return
+	}
+
+	if !validateInputType(c, req.InputType) {
+		return
+	}
+
+	if req.TimeoutMs < 0 {
+		c.JSON(http.StatusBadRequest, models.Error{
+			Code:    "invalid_request",
+			Message: "timeout_ms must not be negative",
+		})
+		return
+	}
+
 	// Create job
-	job := h.jobStore.CreateJob(req.Files, req.Model, req.CallbackURL)
+	job := h.jobStore.CreateJob(&req)
 
 	// Enqueue for processing
 	h.worker.EnqueueJob(job.JobID)
@@ -221,6 +221,55 @@ func (h *Handler) CreateJob(c *gin.Context) {
 	})
 }
 
+// ListModels handles GET /v1/models
+func (h *Handler) ListModels(c *gin.Context) {
+	registered := h.embeddingService.Models().List()
+
+	infos := make([]models.ModelInfo, 0, len(registered))
+	for _, model := range registered {
+		infos = append(infos, modelInfo(model))
+	}
+
+	c.JSON(http.StatusOK, gin.H{"models": infos})
+}
+
+// GetModel handles GET /v1/models/:model_id
+func (h *Handler) GetModel(c *gin.Context) {
+	model, err := h.embeddingService.LookupModel(c.Param("model_id"))
+	if err != nil {
+		c.JSON(http.StatusNotFound, models.Error{
+			Code:    "not_found",
+			Message: "Model not found",
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, modelInfo(model))
+}
+
+// modelInfo describes a registered model for API responses
+func modelInfo(model *services.Model) models.ModelInfo {
+	fallback := make([]string, 0)
+	for _, provider := range model.Fallbacks() {
+		fallback = append(fallback, provider.Name()+":"+provider.Model())
+	}
+
+	return models.ModelInfo{
+		ID:                 model.Name,
+		Provider:           model.Primary().Name(),
+		UpstreamModel:      model.Primary().Model(),
+		Fallback:           fallback,
+		Dimension:          model.Dimension,
+		MaxChunkSize:       model.MaxInputLength,
+		MaxInputTokens:     model.MaxInputTokens,
+		Tokenizer:          model.Tokenizer.Name(),
+		TruncateStrategies: services.SupportedTruncateStrategies,
+		ChunkUnits:         services.SupportedChunkUnits,
+		NormalizeDefault:   model.Normalize,
+		Status:             model.Status(),
+	}
+}
+
 // GetJob handles GET /v1/jobs/:job_id
 func (h *Handler) GetJob(c *gin.Context) {
 	jobID := c.Param("job_id")
@@ -240,6 +289,8 @@ func (h *Handler) GetJob(c *gin.Context) {
 		Progress:   job.Progress,
 		ResultURLs: job.ResultURLs,
 		Error:      job.Error,
+		Dedup:      job.Dedup,
+		Usage:      job.Usage,
 	})
 }
 
@@ -266,8 +317,378 @@ func (h *Handler) ListJobs(c *gin.Context) {
 			Progress:   job.Progress,
 			ResultURLs: job.ResultURLs,
 			Error:      job.Error,
+			Dedup:      job.Dedup,
+			Usage:      job.Usage,
 		})
 	}
 
 	c.JSON(http.StatusOK, gin.H{"jobs": statuses})
 }
+
+// lookupModel resolves a model name, responding with invalid_request when
+// the model is not registered
+func (h *Handler) lookupModel(c *gin.Context, name string) (*services.Model, bool) {
+	model, err := h.embeddingService.LookupModel(name)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, models.Error{
+			Code:    "invalid_request",
+			Message: err.Error(),
+		})
+		return nil, false
+	}
+	return model, true
+}
+
+// bindEmbedRequest binds and validates the JSON body of /v1/embed and
+// /v1/chunk, responding with an error when it is invalid
+func (h *Handler) bindEmbedRequest(c *gin.Context) (*models.EmbedRequest, bool) {
+	var req models.EmbedRequest
+
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, models.Error{
+			Code:    "invalid_request",
+			Message: err.Error(),
+		})
+		return nil, false
+	}
+
+	// Validate batch size
+	if len(req.Inputs) > h.config.MaxBatchSize {
+		c.JSON(http.StatusRequestEntityTooLarge, models.Error{
+			Code:    "payload_too_large",
+			Message: "Batch size exceeds maximum allowed",
+		})
+		return nil, false
+	}
+
+	// Validate model
+	model, ok := h.lookupModel(c, req.Model)
+	if !ok {
+		return nil, false
+	}
+
+	if !h.validateChunking(c, model, req.ChunkSize, req.ChunkUnit, req.ChunkOverlap) {
+		return nil, false
+	}
+
+	if !validateTruncateStrategy(c, req.TruncateStrategy) {
+		return nil, false
+	}
+
+	if !validateSemantic(c, req.MinChunkSize, req.BreakpointPercentile) {
+		return nil, false
+	}
+
+	if !validateLanguage(c, req.Language) {
+		return nil, false
+	}
+	for _, input := range req.Inputs {
+		if !validateLanguage(c, input.Language) {
+			return nil, false
+		}
+	}
+
+	if !validateInputType(c, req.InputType) {
+		return nil, false
+	}
+
+	return &req, true
+}
+
+// bindFileRequest extracts the text of the file uploaded to /v1/embed/file
+// or /v1/chunk and binds the form fields, responding with an error when the
+// file or a field is invalid
+func (h *Handler) bindFileRequest(ctx context.Context, c *gin.Context) (*models.EmbedRequest, bool) {
+	// Get file from form
+	file, header, err := c.Request.FormFile("file")
+	if err != nil {
+		c.JSON(http.StatusBadRequest, models.Error{
+			Code:    "invalid_request",
+			Message: "No file uploaded",
+		})
+		return nil, false
+	}
+	defer file.Close()
+
+	// Validate file type
+	if !services.IsSupportedFile(header.Filename) {
+		c.JSON(http.StatusBadRequest, models.Error{
+			Code:    "invalid_request",
+			Message: "Unsupported file type. Only PDF, TXT, Markdown and source code files are allowed.",
+		})
+		return nil, false
+	}
+
+	// Check file size
+	fileSizeMB := float64(header.Size) / (1024 * 1024)
+	if fileSizeMB > float64(h.config.SyncFileLimitMB) {
+		// Too large for sync processing - create async job
+		// For now, just return an error. Full async would save file first.
+		c.JSON(http.StatusRequestEntityTooLarge, models.Error{
+			Code:    "payload_too_large",
+			Message: "File too large for synchronous processing. Use /v1/jobs for async processing.",
+		})
+		return nil, false
+	}
+
+	// Read file content
+	content, err := io.ReadAll(file)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, models.Error{
+			Code:    "internal_error",
+			Message: "Failed to read file",
+		})
+		return nil, false
+	}
+
+	// Extract text from file
+	extracted, err := h.embeddingService.ExtractTextFromFile(ctx, header.Filename, content)
+	if err != nil {
+		if ctx.Err() != nil {
+			respondEmbeddingError(c, err)
+			return nil, false
+		}
+		c.JSON(http.StatusBadRequest, models.Error{
+			Code:    services.ExtractionErrorCode(err, "invalid_request"),
+			Message: err.Error(),
+		})
+		return nil, false
+	}
+
+	// Get form parameters
+	modelName := c.DefaultPostForm("model", h.embeddingService.Models().Default().Name)
+	model, ok := h.lookupModel(c, modelName)
+	if !ok {
+		return nil, false
+	}
+	language := c.DefaultPostForm("language", extracted.Language)
+	if !validateLanguage(c, language) {
+		return nil, false
+	}
+	defaultStrategy := services.TruncateStrategyForFile(header.Filename, language)
+	truncateStrategy := c.DefaultPostForm("truncate_strategy", defaultStrategy)
+	if !validateTruncateStrategy(c, truncateStrategy) {
+		return nil, false
+	}
+	chunkSize, _ := strconv.Atoi(c.PostForm("chunk_size"))
+	chunkUnit := c.PostForm("chunk_unit")
+	chunkOverlap, _ := strconv.Atoi(c.PostForm("chunk_overlap"))
+	if !h.validateChunking(c, model, chunkSize, chunkUnit, chunkOverlap) {
+		return nil, false
+	}
+	minChunkSize, _ := strconv.Atoi(c.PostForm("min_chunk_size"))
+	breakpointPercentile, _ := strconv.Atoi(c.PostForm("breakpoint_percentile"))
+	if !validateSemantic(c, minChunkSize, breakpointPercentile) {
+		return nil, false
+	}
+	normalize := c.DefaultPostForm("normalize", "true") == "true"
+	prependHeadingPath := c.PostForm("prepend_heading_path") == "true"
+	splitAtPages := c.PostForm("split_at_pages") == "true"
+	inputType := c.PostForm("input_type")
+	if !validateInputType(c, inputType) {
+		return nil, false
+	}
+
+	return &models.EmbedRequest{
+		Model:                model.Name,
+		Inputs:               []models.InputItem{{ID: header.Filename, Text: extracted.Text, Source: header.Filename, Pages: extracted.Pages}},
+		TruncateStrategy:     truncateStrategy,
+		ChunkSize:            chunkSize,
+		ChunkUnit:            chunkUnit,
+		ChunkOverlap:         chunkOverlap,
+		MinChunkSize:         minChunkSize,
+		BreakpointPercentile: breakpointPercentile,
+		SplitAtPages:         splitAtPages,
+		PrependHeadingPath:   prependHeadingPath,
+		Language:             language,
+		Normalize:            &normalize,
+		InputType:            inputType,
+	}, true
+}
+
+// validateTruncateStrategy responds with invalid_request unless strategy is
+// empty or supported
+func validateTruncateStrategy(c *gin.Context, strategy string) bool {
+	if strategy == "" || services.IsSupportedTruncateStrategy(strategy) {
+		return true
+	}
+	c.JSON(http.StatusBadRequest, models.Error{
+		Code:    "invalid_request",
+		Message: "truncate_strategy must be one of: " + strings.Join(services.SupportedTruncateStrategies, ", "),
+	})
+	return false
+}
+
+// validateSemantic responds with invalid_request when the semantic chunking
+// parameters are out of range
+func validateSemantic(c *gin.Context, minChunkSize, breakpointPercentile int) bool {
+	if minChunkSize < 0 {
+		c.JSON(http.StatusBadRequest, models.Error{
+			Code:    "invalid_request",
+			Message: "min_chunk_size must not be negative",
+		})
+		return false
+	}
+	if breakpointPercentile < 0 || breakpointPercentile > 99 {
+		c.JSON(http.StatusBadRequest, models.Error{
+			Code:    "invalid_request",
+			Message: "breakpoint_percentile must be 0 (default) or between 1 and 99",
+		})
+		return false
+	}
+	return true
+}
+
+// validateLanguage responds with invalid_request unless language is empty or
+// supported for code chunking
+func validateLanguage(c *gin.Context, language string) bool {
+	if services.IsSupportedLanguage(language) {
+		return true
+	}
+	c.JSON(http.StatusBadRequest, models.Error{
+		Code:    "invalid_request",
+		Message: "language must be one of: " + strings.Join(services.SupportedLanguages, ", "),
+	})
+	return false
+}
+
+// validateInputType responds with invalid_request when inputType is not supported
+func validateInputType(c *gin.Context, inputType string) bool {
+	if services.IsSupportedInputType(inputType) {
+		return true
+	}
+	c.JSON(http.StatusBadRequest, models.Error{
+		Code:    "invalid_request",
+		Message: "input_type must be one of: " + strings.Join(services.SupportedInputTypes, ", "),
+	})
+	return false
+}
+
+// validateChunking responds with invalid_request when the chunk unit is not
+// supported, the chunk size does not fit the model, or the overlap is not
+// smaller than the chunk size
+func (h *Handler) validateChunking(c *gin.Context, model *services.Model, chunkSize int, chunkUnit string, chunkOverlap int) bool {
+	if !services.IsSupportedChunkUnit(chunkUnit) {
+		c.JSON(http.StatusBadRequest, models.Error{
+			Code:    "invalid_request",
+			Message: "chunk_unit must be one of: " + strings.Join(services.SupportedChunkUnits, ", "),
+		})
+		return false
+	}
+
+	maxChunkSize := model.MaxInputLength
+	if chunkUnit == services.ChunkUnitTokens && model.MaxInputTokens > 0 {
+		maxChunkSize = model.MaxInputTokens
+	}
+	if chunkSize < 0 || chunkSize > maxChunkSize {
+		c.JSON(http.StatusBadRequest, models.Error{
+			Code:    "invalid_request",
+			Message: "Invalid chunk_size",
+		})
+		return false
+	}
+
+	if chunkOverlap < 0 || chunkOverlap > h.config.MaxChunkSize ||
+		(chunkOverlap > 0 && chunkOverlap >= h.embeddingService.ChunkSize(model, chunkSize, chunkUnit)) {
+		c.JSON(http.StatusBadRequest, models.Error{
+			Code:    "invalid_request",
+			Message: "chunk_overlap must be at least 0 and smaller than chunk_size",
+		})
+		return false
+	}
+	return true
+}
+
+// requestContext derives the context for an embedding request from the
+// client connection. The deadline comes from timeoutMs, or the
+// X-Request-Timeout-Ms header when timeoutMs is zero, and is capped at
+// REQUEST_TIMEOUT_SECONDS.
+func (h *Handler) requestContext(c *gin.Context, timeoutMs int) (context.Context, context.CancelFunc, bool) {
+	if timeoutMs == 0 {
+		if header := c.GetHeader("X-Request-Timeout-Ms"); header != "" {
+			parsed, err := strconv.Atoi(header)
+			if err != nil {
+				c.JSON(http.StatusBadRequest, models.Error{
+					Code:    "invalid_request",
+					Message: "X-Request-Timeout-Ms must be an integer",
+				})
+				return nil, nil, false
+			}
+			timeoutMs = parsed
+		}
+	}
+
+	if timeoutMs < 0 {
+		c.JSON(http.StatusBadRequest, models.Error{
+			Code:    "invalid_request",
+			Message: "timeout_ms must not be negative",
+		})
+		return nil, nil, false
+	}
+
+	timeout := time.Duration(h.config.RequestTimeoutSeconds) * time.Second
+	if requested := time.Duration(timeoutMs) * time.Millisecond; requested > 0 && requested < timeout {
+		timeout = requested
+	}
+
+	ctx, cancel := context.WithTimeout(c.Request.Context(), timeout)
+	return ctx, cancel, true
+}
+
+// respondEmbeddingError maps embedding failures to API error responses
+func respondEmbeddingError(c *gin.Context, err error) {
+	if errors.Is(err, context.DeadlineExceeded) {
+		c.JSON(http.StatusGatewayTimeout, models.Error{
+			Code:    "request_timeout",
+			Message: "Request deadline exceeded",
+		})
+		return
+	}
+
+	if errors.Is(err, context.Canceled) {
+		// The client has gone away; the status is only recorded in access logs
+		c.JSON(statusClientClosedRequest, models.Error{
+			Code:    "request_cancelled",
+			Message: "Request cancelled by client",
+		})
+		return
+	}
+
+	if errors.Is(err, services.ErrUnknownModel) {
+		c.JSON(http.StatusBadRequest, models.Error{
+			Code:    "invalid_request",
+			Message: err.Error(),
+		})
+		return
+	}
+
+	if errors.Is(err, services.ErrInputTooLong) {
+		c.JSON(http.StatusBadRequest, models.Error{
+			Code:    "input_too_long",
+			Message: err.Error(),
+		})
+		return
+	}
+
+	var providerErr *services.ProviderError
+	if !errors.As(err, &providerErr) {
+		c.JSON(http.StatusInternalServerError, models.Error{
+			Code:    "internal_error",
+			Message: err.Error(),
+		})
+		return
+	}
+
+	status := http.StatusBadGateway
+	switch providerErr.Code {
+	case services.ErrCodeUpstreamTimeout:
+		status = http.StatusGatewayTimeout
+	case services.ErrCodeProviderUnavailable:
+		status = http.StatusServiceUnavailable
+	}
+
+	c.JSON(status, models.Error{
+		Code:    providerErr.Code,
+		Message: providerErr.Error(),
+	})
+}
@@ -27,7 +27,10 @@ func main() {
 	}
 
 	// Initialize services
-	embeddingService := services.NewEmbeddingService(cfg)
+	embeddingService, err := services.NewEmbeddingService(cfg)
+	if err != nil {
+		log.Fatalf("Failed to initialize embedding service: %v", err)
+	}
 	jobStore := services.NewJobStore()
 	worker := services.NewWorker(cfg, jobStore, embeddingService)
 
@@ -61,6 +64,13 @@ func main() {
 		// File upload embedding
 		api.POST("/embed/file", handler.EmbedFile)
 
+		// Chunk preview
+		api.POST("/chunk", handler.Chunk)
+
+		// Models
+		api.GET("/models", handler.ListModels)
+		api.GET("/models/:model_id", handler.GetModel)
+
 		// Async jobs
 		api.POST("/jobs", handler.CreateJob)
 		api.GET("/jobs", handler.ListJobs)
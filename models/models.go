@@ -2,29 +2,65 @@ package models
 
 // EmbedRequest represents the request body for /v1/embed
 type EmbedRequest struct {
-	Model            string      `json:"model" binding:"required"`
-	Inputs           []InputItem `json:"inputs" binding:"required,min=1"`
-	TruncateStrategy string      `json:"truncate_strategy,omitempty"` // "truncate" or "split"
-	ChunkSize        int         `json:"chunk_size,omitempty"`
-	Normalize        bool        `json:"normalize,omitempty"`
+	Model                string      `json:"model" binding:"required"`
+	Inputs               []InputItem `json:"inputs" binding:"required,min=1"`
+	TruncateStrategy     string      `json:"truncate_strategy,omitempty"` // "truncate", "split", "recursive", "markdown", "code" or "semantic"
+	ChunkSize            int         `json:"chunk_size,omitempty"`
+	ChunkUnit            string      `json:"chunk_unit,omitempty"` // "chars" (default) or "tokens"
+	ChunkOverlap         int         `json:"chunk_overlap,omitempty"`
+	MinChunkSize         int         `json:"min_chunk_size,omitempty"`        // semantic chunks end at a breakpoint only once this long
+	BreakpointPercentile int         `json:"breakpoint_percentile,omitempty"` // semantic breakpoint threshold, 1-99
+	SplitAtPages         bool        `json:"split_at_pages,omitempty"`        // chunk each page of a PDF on its own
+	// PrependHeadingPath embeds Markdown chunks as "heading path\n\ntext"
+	PrependHeadingPath bool   `json:"prepend_heading_path,omitempty"`
+	Language           string `json:"language,omitempty"`   // source language for the code strategy, e.g. "go"
+	Normalize          *bool  `json:"normalize,omitempty"`  // defaults to the model's setting
+	InputType          string `json:"input_type,omitempty"` // "query", "document", "classification", "clustering"
+	TimeoutMs          int    `json:"timeout_ms,omitempty"`
 }
 
 // InputItem represents a single text input
 type InputItem struct {
-	ID   string `json:"id" binding:"required"`
-	Text string `json:"text" binding:"required"`
+	ID       string `json:"id" binding:"required"`
+	Text     string `json:"text" binding:"required"`
+	Language string `json:"language,omitempty"` // overrides the request's language
+	Source   string `json:"-"`                  // file the text was extracted from
+	Pages    []int  `json:"-"`                  // character offset of each page of an extracted PDF
 }
 
 // EmbedResponse represents the response for /v1/embed
 type EmbedResponse struct {
 	Results []EmbedResult `json:"results"`
+	Dedup   *DedupSummary `json:"dedup,omitempty"`
+	Usage   *Usage        `json:"usage,omitempty"`
+}
+
+// Usage reports the upstream cost of a request
+type Usage struct {
+	PromptTokens  int `json:"prompt_tokens"` // tokens sent upstream, after dedup and caching
+	Chunks        int `json:"chunks"`        // vectors returned, one per unchunked input or chunk
+	UpstreamCalls int `json:"upstream_calls"`
+}
+
+// DedupSummary reports how many upstream embeddings were saved by
+// collapsing identical texts and chunks within a request
+type DedupSummary struct {
+	Texts  int `json:"texts"`
+	Unique int `json:"unique"`
+	Saved  int `json:"saved"`
 }
 
 // EmbedResult represents embedding result for a single input
 type EmbedResult struct {
 	ID         string    `json:"id"`
+	Provider   string    `json:"provider,omitempty"`
+	Model      string    `json:"model,omitempty"`
 	Embeddings []float32 `json:"embeddings,omitempty"`
 	Chunks     []Chunk   `json:"chunks,omitempty"`
+	PageStart  int       `json:"page_start,omitempty"` // page range of a PDF embedded whole
+	PageEnd    int       `json:"page_end,omitempty"`
+	Source     string    `json:"source,omitempty"` // file an input embedded whole comes from
+	Cached     bool      `json:"cached,omitempty"` // every vector came from the embedding cache
 }
 
 // Chunk represents a text chunk with its embedding
@@ -33,53 +69,157 @@ type Chunk struct {
 	Start       int       `json:"start"`
 	End         int       `json:"end"`
 	TextSnippet string    `json:"text_snippet"`
+	HeadingPath string    `json:"heading_path,omitempty"` // e.g. "Install > Linux > Troubleshooting"
+	Symbols     []string  `json:"symbols,omitempty"`      // declarations in a code chunk
+	StartLine   int       `json:"start_line,omitempty"`   // 1-based line range of a code chunk
+	EndLine     int       `json:"end_line,omitempty"`
+	PageStart   int       `json:"page_start,omitempty"` // 1-based page range of a PDF chunk
+	PageEnd     int       `json:"page_end,omitempty"`
+	Source      string    `json:"source,omitempty"` // file the chunk comes from
 	Embedding   []float32 `json:"embedding"`
+	Cached      bool      `json:"cached,omitempty"`
+}
+
+// ChunkResponse represents the response for /v1/chunk
+type ChunkResponse struct {
+	Results  []ChunkResult `json:"results"`
+	Estimate ChunkEstimate `json:"estimate"`
+	Usage    *Usage        `json:"usage,omitempty"` // sentences embedded by semantic chunking
+}
+
+// ChunkResult lists the chunks /v1/embed would embed for an input
+type ChunkResult struct {
+	ID      string         `json:"id"`
+	Chunked bool           `json:"chunked"` // false when the input is embedded whole
+	Chunks  []ChunkPreview `json:"chunks"`
+}
+
+// ChunkPreview describes a chunk without its embedding
+type ChunkPreview struct {
+	ChunkID     string   `json:"chunk_id"`
+	Start       int      `json:"start"`
+	End         int      `json:"end"`
+	Tokens      int      `json:"tokens"`
+	TextSnippet string   `json:"text_snippet"`
+	HeadingPath string   `json:"heading_path,omitempty"`
+	Symbols     []string `json:"symbols,omitempty"`
+	StartLine   int      `json:"start_line,omitempty"`
+	EndLine     int      `json:"end_line,omitempty"`
+	PageStart   int      `json:"page_start,omitempty"`
+	PageEnd     int      `json:"page_end,omitempty"`
+	Source      string   `json:"source,omitempty"`
+}
+
+// ChunkEstimate is the upstream cost of embedding the chunks with an empty
+// embedding cache
+type ChunkEstimate struct {
+	Texts         int    `json:"texts"`
+	Unique        int    `json:"unique"`
+	PromptTokens  int    `json:"prompt_tokens"`
+	UpstreamCalls int    `json:"upstream_calls"`
+	Provider      string `json:"provider"`
 }
 
 // FileEmbedRequest represents the request for file upload
 type FileEmbedRequest struct {
-	Model            string `form:"model"`
-	TruncateStrategy string `form:"truncate_strategy"`
-	ChunkSize        int    `form:"chunk_size"`
-	Normalize        bool   `form:"normalize"`
+	Model                string `form:"model"`
+	TruncateStrategy     string `form:"truncate_strategy"`
+	ChunkSize            int    `form:"chunk_size"`
+	ChunkUnit            string `form:"chunk_unit"`
+	ChunkOverlap         int    `form:"chunk_overlap"`
+	MinChunkSize         int    `form:"min_chunk_size"`
+	BreakpointPercentile int    `form:"breakpoint_percentile"`
+	PrependHeadingPath   bool   `form:"prepend_heading_path"`
+	SplitAtPages         bool   `form:"split_at_pages"`
+	Language             string `form:"language"` // inferred from the file extension when empty
+	Normalize            bool   `form:"normalize"`
+	InputType            string `form:"input_type"`
+	TimeoutMs            int    `form:"timeout_ms"`
 }
 
 // AsyncJobRequest represents the request for async job creation
 type AsyncJobRequest struct {
-	Model       string   `json:"model" binding:"required"`
-	Files       []string `json:"files" binding:"required,min=1"`
-	CallbackURL string   `json:"callback_url,omitempty"`
-	Priority    string   `json:"priority,omitempty"` // "low", "normal", "high"
+	Model                string   `json:"model" binding:"required"`
+	Files                []string `json:"files" binding:"required,min=1"`
+	CallbackURL          string   `json:"callback_url,omitempty"`
+	Priority             string   `json:"priority,omitempty"`          // "low", "normal", "high"
+	TruncateStrategy     string   `json:"truncate_strategy,omitempty"` // defaults per file as for /v1/embed/file
+	ChunkSize            int      `json:"chunk_size,omitempty"`
+	ChunkUnit            string   `json:"chunk_unit,omitempty"`
+	ChunkOverlap         int      `json:"chunk_overlap,omitempty"`
+	MinChunkSize         int      `json:"min_chunk_size,omitempty"`
+	BreakpointPercentile int      `json:"breakpoint_percentile,omitempty"`
+	PrependHeadingPath   bool     `json:"prepend_heading_path,omitempty"`
+	SplitAtPages         bool     `json:"split_at_pages,omitempty"`
+	Normalize            *bool    `json:"normalize,omitempty"` // defaults to true
+	InputType            string   `json:"input_type,omitempty"`
+	TimeoutMs            int      `json:"timeout_ms,omitempty"`
 }
 
 // Job represents an async embedding job
 type Job struct {
-	JobID       string   `json:"job_id"`
-	Status      string   `json:"status"` // "queued", "running", "completed", "failed"
-	Progress    int      `json:"progress,omitempty"`
-	Files       []string `json:"files"`
-	Model       string   `json:"model"`
-	ResultURLs  []string `json:"result_urls,omitempty"`
-	Error       *Error   `json:"error,omitempty"`
-	CreatedAt   int64    `json:"created_at"`
-	UpdatedAt   int64    `json:"updated_at"`
-	CallbackURL string   `json:"callback_url,omitempty"`
+	JobID                string        `json:"job_id"`
+	Status               string        `json:"status"` // "queued", "running", "completed", "failed"
+	Progress             int           `json:"progress,omitempty"`
+	Files                []string      `json:"files"`
+	Model                string        `json:"model"`
+	ResultURLs           []string      `json:"result_urls,omitempty"`
+	Error                *Error        `json:"error,omitempty"`
+	CreatedAt            int64         `json:"created_at"`
+	UpdatedAt            int64         `json:"updated_at"`
+	CallbackURL          string        `json:"callback_url,omitempty"`
+	TruncateStrategy     string        `json:"truncate_strategy,omitempty"`
+	ChunkSize            int           `json:"chunk_size,omitempty"`
+	ChunkUnit            string        `json:"chunk_unit,omitempty"`
+	ChunkOverlap         int           `json:"chunk_overlap,omitempty"`
+	MinChunkSize         int           `json:"min_chunk_size,omitempty"`
+	BreakpointPercentile int           `json:"breakpoint_percentile,omitempty"`
+	PrependHeadingPath   bool          `json:"prepend_heading_path,omitempty"`
+	SplitAtPages         bool          `json:"split_at_pages,omitempty"`
+	Normalize            *bool         `json:"normalize,omitempty"`
+	InputType            string        `json:"input_type,omitempty"`
+	TimeoutMs            int           `json:"timeout_ms,omitempty"`
+	Dedup                *DedupSummary `json:"dedup,omitempty"`
+	Usage                *Usage        `json:"usage,omitempty"`
 }
 
 // JobStatus represents job status response
 type JobStatus struct {
-	JobID      string   `json:"job_id"`
-	Status     string   `json:"status"`
-	Progress   int      `json:"progress"`
-	ResultURLs []string `json:"result_urls,omitempty"`
-	Error      *Error   `json:"error,omitempty"`
+	JobID      string        `json:"job_id"`
+	Status     string        `json:"status"`
+	Progress   int           `json:"progress"`
+	ResultURLs []string      `json:"result_urls,omitempty"`
+	Error      *Error        `json:"error,omitempty"`
+	Dedup      *DedupSummary `json:"dedup,omitempty"`
+	Usage      *Usage        `json:"usage,omitempty"`
 }
 
 // HealthResponse represents health check response
 type HealthResponse struct {
-	Status     string `json:"status"`
-	Version    string `json:"version"`
-	QueueDepth int    `json:"queue_depth"`
+	Status     string           `json:"status"`
+	Version    string           `json:"version"`
+	QueueDepth int              `json:"queue_depth"`
+	Providers  []ProviderHealth `json:"providers,omitempty"`
+	Cache      *CacheHealth     `json:"cache,omitempty"`
+}
+
+// CacheHealth represents the embedding cache counters
+type CacheHealth struct {
+	Entries  int   `json:"entries"`
+	Hits     int64 `json:"hits"`
+	DiskHits int64 `json:"disk_hits"`
+	Misses   int64 `json:"misses"`
+}
+
+// ProviderHealth represents the circuit breaker state of a provider
+type ProviderHealth struct {
+	Name                string `json:"name"`
+	Model               string `json:"model"`
+	EmbeddingModel      string `json:"embedding_model"`
+	State               string `json:"state"` // "closed", "open", "half_open"
+	ConsecutiveFailures int    `json:"consecutive_failures"`
+	OpenedAt            int64  `json:"opened_at,omitempty"`
+	LastError           string `json:"last_error,omitempty"`
 }
 
 // Error represents API error response
@@ -94,3 +234,19 @@ type AsyncAcceptedResponse struct {
 	Status  string `json:"status"`
 	Message string `json:"message"`
 }
+
+// ModelInfo describes an embedding model available through the API
+type ModelInfo struct {
+	ID                 string   `json:"id"`
+	Provider           string   `json:"provider"`
+	UpstreamModel      string   `json:"upstream_model"`
+	Fallback           []string `json:"fallback,omitempty"`
+	Dimension          int      `json:"dimension"`
+	MaxChunkSize       int      `json:"max_chunk_size"`
+	MaxInputTokens     int      `json:"max_input_tokens,omitempty"`
+	Tokenizer          string   `json:"tokenizer"`
+	TruncateStrategies []string `json:"truncate_strategies"`
+	ChunkUnits         []string `json:"chunk_units"`
+	NormalizeDefault   bool     `json:"normalize_default"`
+	Status             string   `json:"status"` // "available", "degraded", "unavailable"
+}
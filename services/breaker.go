@@ -0,0 +1,121 @@
+package services
+
+import (
+	"sync"
+	"time"
+)
+
+// Circuit breaker states
+const (
+	BreakerClosed   = "closed"
+	BreakerOpen     = "open"
+	BreakerHalfOpen = "half_open"
+)
+
+// CircuitBreaker stops sending traffic to a provider after repeated failures
+// and lets a single probe request through once the cooldown has elapsed
+type CircuitBreaker struct {
+	mutex     sync.Mutex
+	state     string
+	failures  int
+	threshold int
+	cooldown  time.Duration
+	openedAt  time.Time
+	probing   bool
+	lastError string
+}
+
+// BreakerSnapshot is a point-in-time view of a circuit breaker
+type BreakerSnapshot struct {
+	State               string
+	ConsecutiveFailures int
+	OpenedAt            time.Time
+	LastError           string
+}
+
+// NewCircuitBreaker creates a closed breaker that opens after threshold
+// consecutive failures and stays open for cooldown
+func NewCircuitBreaker(threshold int, cooldown time.Duration) *CircuitBreaker {
+	if threshold <= 0 {
+		threshold = 1
+	}
+	return &CircuitBreaker{
+		state:     BreakerClosed,
+		threshold: threshold,
+		cooldown:  cooldown,
+	}
+}
+
+// Allow reports whether a request may be sent through the breaker
+func (b *CircuitBreaker) Allow() bool {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	switch b.state {
+	case BreakerOpen:
+		if time.Since(b.openedAt) < b.cooldown {
+			return false
+		}
+		b.state = BreakerHalfOpen
+		b.probing = true
+		return true
+	case BreakerHalfOpen:
+		if b.probing {
+			return false
+		}
+		b.probing = true
+		return true
+	default:
+		return true
+	}
+}
+
+// RecordSuccess closes the breaker and resets the failure count
+func (b *CircuitBreaker) RecordSuccess() {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	b.state = BreakerClosed
+	b.failures = 0
+	b.probing = false
+}
+
+// RecordFailure counts a failure and opens the breaker when the threshold
+// is reached or a half-open probe fails
+func (b *CircuitBreaker) RecordFailure(err error) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	b.failures++
+	b.probing = false
+	if err != nil {
+		b.lastError = err.Error()
+	}
+
+	if b.state == BreakerHalfOpen || b.failures >= b.threshold {
+		b.state = BreakerOpen
+		b.openedAt = time.Now()
+	}
+}
+
+// RecordAbandoned releases a half-open probe whose request was cancelled by
+// the caller, without counting it as a success or a failure
+func (b *CircuitBreaker) RecordAbandoned() {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	b.probing = false
+}
+
+// Snapshot returns the current breaker state
+func (b *CircuitBreaker) Snapshot() BreakerSnapshot {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	return BreakerSnapshot{
+		State:               b.state,
+		ConsecutiveFailures: b.failures,
+		OpenedAt:            b.openedAt,
+		LastError:           b.lastError,
+	}
+}
@@ -0,0 +1,194 @@
+package services
+
+import (
+	"container/list"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"math"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+)
+
+// EmbeddingCache stores embeddings by content hash in an in-memory LRU tier
+// backed by an optional on-disk tier
+type EmbeddingCache struct {
+	mutex      sync.Mutex
+	maxEntries int
+	entries    map[string]*list.Element
+	order      *list.List // front is most recently used
+	diskPath   string     // empty disables the disk tier
+
+	hits     atomic.Int64
+	diskHits atomic.Int64
+	misses   atomic.Int64
+}
+
+// cacheEntry is an element of the LRU list
+type cacheEntry struct {
+	key    string
+	vector []float32
+}
+
+// CacheStats is a snapshot of cache counters
+type CacheStats struct {
+	Entries  int
+	Hits     int64
+	DiskHits int64
+	Misses   int64
+}
+
+// NewEmbeddingCache creates a cache holding up to maxEntries vectors in
+// memory. When diskPath is not empty, vectors are also persisted there and
+// survive restarts.
+func NewEmbeddingCache(maxEntries int, diskPath string) (*EmbeddingCache, error) {
+	if diskPath != "" {
+		if err := os.MkdirAll(diskPath, 0755); err != nil {
+			return nil, fmt.Errorf("failed to create cache directory: %w", err)
+		}
+	}
+
+	return &EmbeddingCache{
+		maxEntries: maxEntries,
+		entries:    make(map[string]*list.Element),
+		order:      list.New(),
+		diskPath:   diskPath,
+	}, nil
+}
+
+// embeddingCacheKey identifies a vector by everything that influences it
+func embeddingCacheKey(model string, provider EmbeddingProvider, inputType string, normalize bool, text string) string {
+	hasher := sha256.New()
+	fmt.Fprintf(hasher, "%s\x00%s\x00%s\x00%d\x00%s\x00%t\x00", model, provider.Name(), provider.Model(), provider.Dimension(), inputType, normalize)
+	hasher.Write([]byte(text))
+	return hex.EncodeToString(hasher.Sum(nil))
+}
+
+// Get returns the cached vector for key, checking memory first and then
+// disk. A vector whose length is not dimension counts as a miss.
+func (c *EmbeddingCache) Get(key string, dimension int) ([]float32, bool) {
+	c.mutex.Lock()
+	if elem, exists := c.entries[key]; exists && len(elem.Value.(*cacheEntry).vector) == dimension {
+		c.order.MoveToFront(elem)
+		vector := elem.Value.(*cacheEntry).vector
+		c.mutex.Unlock()
+		c.hits.Add(1)
+		return vector, true
+	}
+	c.mutex.Unlock()
+
+	if c.diskPath != "" {
+		if vector, err := c.readDisk(key, dimension); err == nil {
+			c.putMemory(key, vector)
+			c.hits.Add(1)
+			c.diskHits.Add(1)
+			return vector, true
+		}
+	}
+
+	c.misses.Add(1)
+	return nil, false
+}
+
+// Put stores a vector in memory and, when enabled, on disk
+func (c *EmbeddingCache) Put(key string, vector []float32) {
+	c.putMemory(key, vector)
+
+	if c.diskPath != "" {
+		if err := c.writeDisk(key, vector); err != nil {
+			log.Printf("Failed to write embedding cache entry: %v", err)
+		}
+	}
+}
+
+// Stats returns the current cache counters
+func (c *EmbeddingCache) Stats() CacheStats {
+	c.mutex.Lock()
+	entries := c.order.Len()
+	c.mutex.Unlock()
+
+	return CacheStats{
+		Entries:  entries,
+		Hits:     c.hits.Load(),
+		DiskHits: c.diskHits.Load(),
+		Misses:   c.misses.Load(),
+	}
+}
+
+// putMemory inserts a vector into the LRU tier, evicting the least
+// recently used entry when full
+func (c *EmbeddingCache) putMemory(key string, vector []float32) {
+	if c.maxEntries <= 0 {
+		return
+	}
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if elem, exists := c.entries[key]; exists {
+		elem.Value.(*cacheEntry).vector = vector
+		c.order.MoveToFront(elem)
+		return
+	}
+
+	c.entries[key] = c.order.PushFront(&cacheEntry{key: key, vector: vector})
+	for c.order.Len() > c.maxEntries {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.entries, oldest.Value.(*cacheEntry).key)
+	}
+}
+
+// diskFile returns the path of a disk entry, sharded by key prefix
+func (c *EmbeddingCache) diskFile(key string) string {
+	return filepath.Join(c.diskPath, key[:2], key+".bin")
+}
+
+// readDisk loads a vector of dimension little-endian float32 values
+func (c *EmbeddingCache) readDisk(key string, dimension int) ([]float32, error) {
+	data, err := os.ReadFile(c.diskFile(key))
+	if err != nil {
+		return nil, err
+	}
+	if len(data) != dimension*4 {
+		return nil, fmt.Errorf("corrupt cache entry %s", key)
+	}
+
+	vector := make([]float32, len(data)/4)
+	for i := range vector {
+		vector[i] = math.Float32frombits(binary.LittleEndian.Uint32(data[i*4:]))
+	}
+	return vector, nil
+}
+
+// writeDisk stores a vector atomically so readers never see partial files
+func (c *EmbeddingCache) writeDisk(key string, vector []float32) error {
+	path := c.diskFile(key)
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+
+	data := make([]byte, len(vector)*4)
+	for i, v := range vector {
+		binary.LittleEndian.PutUint32(data[i*4:], math.Float32bits(v))
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(path), key+".*.tmp")
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
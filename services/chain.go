@@ -0,0 +1,189 @@
+package services
+
+import (
+	"batch-embedding-api/config"
+	"context"
+	"errors"
+	"log"
+	"strings"
+	"time"
+)
+
+// chainMember pairs a provider with its circuit breaker and request pool
+type chainMember struct {
+	provider EmbeddingProvider
+	breaker  *CircuitBreaker
+	pool     *ProviderPool
+}
+
+// ProviderChain tries providers in order, skipping those whose circuit
+// breaker is open, until one of them produces embeddings
+type ProviderChain struct {
+	members []chainMember
+}
+
+// NewProviderChain builds the chain serving model: its primary provider
+// followed by its fallbacks. Fallbacks whose dimension differs from the
+// primary are left out, since their vectors could not be mixed with the
+// primary's.
+func NewProviderChain(cfg *config.Config, model config.ModelConfig) (*ProviderChain, error) {
+	entries := append([]string{model.Provider}, model.Fallback...)
+
+	cooldown := time.Duration(cfg.CircuitBreakerCooldownSeconds) * time.Second
+	chain := &ProviderChain{}
+
+	for i, entry := range entries {
+		// Fallback entries may pin an upstream model as "provider:upstream_model"
+		name, upstreamModel, _ := strings.Cut(entry, ":")
+		spec := ProviderSpec{
+			UpstreamModel:  upstreamModel,
+			Dimension:      model.Dimension,
+			MaxInputLength: model.MaxInputLength,
+		}
+		if i == 0 {
+			spec.UpstreamModel = model.UpstreamModel
+			spec.Endpoints = model.Endpoints
+			spec.APIKeys = model.APIKeys
+		}
+
+		provider, err := NewProvider(name, cfg, spec)
+		if err != nil {
+			return nil, err
+		}
+
+		if len(chain.members) > 0 {
+			primary := chain.members[0].provider
+			if provider.Dimension() != primary.Dimension() {
+				log.Printf("Skipping provider %s for model %s: dimension %d does not match %s (%d)",
+					name, model.Name, provider.Dimension(), primary.Name(), primary.Dimension())
+				continue
+			}
+		}
+
+		chain.members = append(chain.members, chainMember{
+			provider: provider,
+			breaker:  NewCircuitBreaker(cfg.CircuitBreakerThreshold, cooldown),
+			pool:     poolForProvider(name, cfg),
+		})
+	}
+
+	return chain, nil
+}
+
+// Primary returns the first provider of the chain
+func (c *ProviderChain) Primary() EmbeddingProvider {
+	return c.members[0].provider
+}
+
+// ChainResult holds the vectors produced by a chain and how they were obtained
+type ChainResult struct {
+	Embeddings    [][]float32
+	Provider      EmbeddingProvider
+	UpstreamCalls int // requests sent to providers, including failed attempts
+}
+
+// Embed embeds texts with the first healthy provider and returns the
+// provider that produced the vectors. All texts are embedded by the same
+// provider so that vectors of one document never mix models.
+func (c *ProviderChain) Embed(ctx context.Context, texts []string, opts EmbedOptions) (*ChainResult, error) {
+	return embedMembers(ctx, c.members, texts, opts)
+}
+
+// EmbedWith embeds texts with provider alone, which must be a member of the
+// chain, so that they match vectors that provider produced earlier
+func (c *ProviderChain) EmbedWith(ctx context.Context, provider EmbeddingProvider, texts []string, opts EmbedOptions) (*ChainResult, error) {
+	for i, member := range c.members {
+		if member.provider == provider {
+			return embedMembers(ctx, c.members[i:i+1], texts, opts)
+		}
+	}
+	return nil, &ProviderError{
+		Code:     ErrCodeProviderUnavailable,
+		Provider: provider.Name(),
+		Message:  "provider is not part of the chain",
+	}
+}
+
+// embedMembers tries members in order until one of them produces embeddings
+func embedMembers(ctx context.Context, members []chainMember, texts []string, opts EmbedOptions) (*ChainResult, error) {
+	var lastErr error
+	skipped := make([]string, 0)
+	calls := 0
+
+	for _, member := range members {
+		if !member.breaker.Allow() {
+			skipped = append(skipped, member.provider.Name())
+			continue
+		}
+
+		embeddings, attempted, err := embedConcurrently(ctx, member.pool, member.provider, texts, opts)
+		calls += attempted
+		if err == nil {
+			err = validateEmbeddings(member.provider, embeddings, len(texts))
+		}
+		if err != nil && ctx.Err() != nil {
+			// The caller gave up; this says nothing about the provider's health
+			member.breaker.RecordAbandoned()
+			return nil, ctx.Err()
+		}
+		var providerErr *ProviderError
+		if errors.As(err, &providerErr) && providerErr.Throttled {
+			// Held back by our own rate limiting; the provider did not fail
+			member.breaker.RecordAbandoned()
+			log.Printf("Provider %s is rate limited: %v", member.provider.Name(), err)
+			lastErr = err
+			continue
+		}
+		if err != nil {
+			member.breaker.RecordFailure(err)
+			log.Printf("Provider %s failed: %v", member.provider.Name(), err)
+			lastErr = err
+			continue
+		}
+
+		member.breaker.RecordSuccess()
+		return &ChainResult{Embeddings: embeddings, Provider: member.provider, UpstreamCalls: calls}, nil
+	}
+
+	if lastErr != nil {
+		return nil, lastErr
+	}
+
+	return nil, &ProviderError{
+		Code:     ErrCodeProviderUnavailable,
+		Provider: strings.Join(skipped, ","),
+		Message:  "circuit breaker open for all providers",
+	}
+}
+
+// Status returns the health of every provider in the chain
+func (c *ProviderChain) Status() []ProviderStatus {
+	statuses := make([]ProviderStatus, 0, len(c.members))
+	for _, member := range c.members {
+		statuses = append(statuses, ProviderStatus{
+			Provider: member.provider,
+			Breaker:  member.breaker.Snapshot(),
+		})
+	}
+	return statuses
+}
+
+// ProviderStatus describes a chain member and its breaker state
+type ProviderStatus struct {
+	Provider EmbeddingProvider
+	Breaker  BreakerSnapshot
+}
+
+// validateEmbeddings checks that a provider returned one vector of the
+// expected dimension per input
+func validateEmbeddings(provider EmbeddingProvider, embeddings [][]float32, count int) error {
+	if len(embeddings) != count {
+		return newMalformedError(provider.Name(), "returned %d embeddings for %d inputs", len(embeddings), count)
+	}
+	for i, emb := range embeddings {
+		if len(emb) != provider.Dimension() {
+			return newMalformedError(provider.Name(), "embedding %d has dimension %d, expected %d", i, len(emb), provider.Dimension())
+		}
+	}
+	return nil
+}
@@ -0,0 +1,472 @@
+package services
+
+import (
+	"batch-embedding-api/models"
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// SupportedTruncateStrategies lists the values accepted for truncate_strategy
+var SupportedTruncateStrategies = []string{"truncate", "split", "recursive", "markdown", "code", "semantic"}
+
+// IsSupportedTruncateStrategy reports whether strategy is a known truncate_strategy
+func IsSupportedTruncateStrategy(strategy string) bool {
+	for _, supported := range SupportedTruncateStrategies {
+		if strategy == supported {
+			return true
+		}
+	}
+	return false
+}
+
+// TruncateStrategyForFile returns the default truncate_strategy of a file:
+// "markdown" for Markdown, "code" for source code in language and "split"
+// for everything else
+func TruncateStrategyForFile(filename, language string) string {
+	switch ext := strings.ToLower(filepath.Ext(filename)); {
+	case ext == ".md" || ext == ".markdown":
+		return "markdown"
+	case language != "":
+		return "code"
+	}
+	return "split"
+}
+
+// Units accepted in the chunk_unit request field
+const (
+	ChunkUnitChars  = "chars"
+	ChunkUnitTokens = "tokens"
+)
+
+// SupportedChunkUnits lists the values accepted for chunk_unit
+var SupportedChunkUnits = []string{ChunkUnitChars, ChunkUnitTokens}
+
+// IsSupportedChunkUnit reports whether unit is empty or a known chunk_unit
+func IsSupportedChunkUnit(unit string) bool {
+	return unit == "" || unit == ChunkUnitChars || unit == ChunkUnitTokens
+}
+
+// TextChunk represents a chunk of text
+type TextChunk struct {
+	ChunkID string
+	Text    string
+	Start   int // character offsets into the original text
+	End     int
+
+	HeadingPath string // enclosing Markdown headings, joined by HeadingPathSeparator
+
+	Symbols   []string // names declared in a code chunk
+	StartLine int      // 1-based line range of a code chunk
+	EndLine   int
+
+	PageStart int // 1-based page range of a chunk of a PDF
+	PageEnd   int
+}
+
+// chunkOptions controls how chunkText splits a text
+type chunkOptions struct {
+	strategy string
+	size     int    // maximum chunk length in unit
+	unit     string // ChunkUnitChars or ChunkUnitTokens
+	overlap  int    // length shared by consecutive split chunks, below size
+	limit    int    // the model's input limit in unit, at least size
+	language string // programming language of the text for code chunking
+
+	// Semantic chunking
+	minSize    int // length a chunk needs before it may end at a breakpoint
+	percentile int // distance percentile above which sentences are breakpoints
+	window     int // neighbouring sentences embedded with each sentence
+	tokenizer  Tokenizer
+}
+
+// ChunkSize returns the chunk size a request with the given chunk_size and
+// chunk_unit uses for model once defaults and limits are applied
+func (s *EmbeddingService) ChunkSize(model *Model, size int, unit string) int {
+	return s.resolveChunkOptions(model, &models.EmbedRequest{ChunkSize: size, ChunkUnit: unit}).size
+}
+
+// resolveChunkOptions applies defaults and the model's limits to the
+// chunking parameters of a request
+func (s *EmbeddingService) resolveChunkOptions(model *Model, req *models.EmbedRequest) chunkOptions {
+	opts := chunkOptions{
+		strategy:   req.TruncateStrategy,
+		size:       req.ChunkSize,
+		unit:       req.ChunkUnit,
+		overlap:    req.ChunkOverlap,
+		language:   req.Language,
+		minSize:    req.MinChunkSize,
+		percentile: req.BreakpointPercentile,
+		window:     s.config.SemanticWindowSentences,
+		tokenizer:  model.Tokenizer,
+	}
+	if opts.strategy == "" {
+		opts.strategy = "truncate"
+	}
+	if opts.unit == "" {
+		opts.unit = ChunkUnitChars
+	}
+
+	if opts.unit == ChunkUnitTokens {
+		if opts.size <= 0 {
+			opts.size = s.config.DefaultChunkTokens
+		}
+		if model.MaxInputTokens > 0 && opts.size > model.MaxInputTokens {
+			opts.size = model.MaxInputTokens
+		}
+	} else {
+		if opts.size <= 0 {
+			opts.size = s.config.DefaultChunkSize
+		}
+	}
+	if opts.size > model.MaxInputLength {
+		opts.size = model.MaxInputLength
+	}
+
+	opts.limit = model.MaxInputLength
+	if opts.unit == ChunkUnitTokens && model.MaxInputTokens > 0 && model.MaxInputTokens < opts.limit {
+		opts.limit = model.MaxInputTokens
+	}
+
+	// Handlers reject larger overlaps; this only guarantees progress
+	if opts.overlap >= opts.size {
+		opts.overlap = opts.size - 1
+	}
+	if opts.overlap < 0 {
+		opts.overlap = 0
+	}
+
+	if opts.minSize > opts.size {
+		opts.minSize = opts.size
+	}
+	if opts.percentile <= 0 || opts.percentile >= 100 {
+		opts.percentile = s.config.SemanticBreakpointPercentile
+	}
+	if opts.window < 0 {
+		opts.window = 0
+	}
+	return opts
+}
+
+// structural reports whether the strategy follows the structure of the text,
+// in which case even short inputs are returned as chunks
+func (o chunkOptions) structural() bool {
+	return o.strategy == "markdown" || o.strategy == "code"
+}
+
+// length measures text in the chunk unit
+func (o chunkOptions) length(text string) int {
+	if o.unit == ChunkUnitTokens {
+		return o.tokenizer.Count(text)
+	}
+	return utf8.RuneCountInString(text)
+}
+
+// chunkText splits text into chunks based on strategy
+func (s *EmbeddingService) chunkText(docID, text string, opts chunkOptions) []TextChunk {
+	units := textUnits(text, opts)
+	switch opts.strategy {
+	case "recursive":
+		return s.chunkRecursive(docID, text, units, opts)
+	case "markdown":
+		return s.chunkMarkdown(docID, text, units, opts)
+	case "code":
+		return s.chunkCode(docID, text, units, opts)
+	}
+	last := len(units) - 1 // units[last] marks the end of the text
+
+	var chunks []TextChunk
+	for start := 0; start < last; {
+		// Take units while they fit, but always at least one
+		end, length := start, 0
+		for end < last && (end == start || length+units[end].weight <= opts.size) {
+			length += units[end].weight
+			end++
+		}
+
+		chunk := TextChunk{
+			Text:  text[units[start].byteOffset:units[end].byteOffset],
+			Start: units[start].charOffset,
+			End:   units[end].charOffset,
+		}
+		if chunk.Text != "" {
+			chunk.ChunkID = fmt.Sprintf("%s_%d", docID, len(chunks))
+			chunks = append(chunks, chunk)
+		}
+
+		if opts.strategy == "truncate" || end == last {
+			// Truncate just returns the first chunk
+			break
+		}
+		start = overlapStart(units, start, end, opts.overlap)
+	}
+
+	return chunks
+}
+
+// overlapStart returns where the chunk after units[start:end] begins: as
+// early as possible while sharing at most overlap with the previous chunk,
+// but always after start so chunking progresses
+func overlapStart(units []textUnit, start, end, overlap int) int {
+	next, shared := end, 0
+	for next-1 > start && shared+units[next-1].weight <= overlap {
+		next--
+		shared += units[next].weight
+	}
+	return next
+}
+
+// textUnit is a position where a chunk may start, weighted by how much of
+// the chunk size the text up to the next unit uses
+type textUnit struct {
+	byteOffset int
+	charOffset int
+	weight     int
+}
+
+// textUnits returns the positions at which chunks of text may start, followed
+// by the end of the text. With chars every character is a unit of weight 1.
+// With tokens a unit starts at each character where a token starts and
+// weighs the number of tokens starting within it, so byte-level tokens that
+// split a character are counted while chunks stay valid UTF-8.
+func textUnits(text string, opts chunkOptions) []textUnit {
+	units := make([]textUnit, 0, len(text)/4+2)
+
+	if opts.unit != ChunkUnitTokens {
+		chars := 0
+		for i := range text {
+			units = append(units, textUnit{byteOffset: i, charOffset: chars, weight: 1})
+			chars++
+		}
+		return append(units, textUnit{byteOffset: len(text), charOffset: chars})
+	}
+
+	chars := 0
+	lastByte := 0
+	for _, token := range opts.tokenizer.Tokens(text) {
+		// Move to the start of the character containing the token start
+		offset := token.Start
+		for offset > 0 && offset < len(text) && !utf8.RuneStart(text[offset]) {
+			offset--
+		}
+
+		if len(units) > 0 && offset <= units[len(units)-1].byteOffset {
+			units[len(units)-1].weight++
+			continue
+		}
+		chars += utf8.RuneCountInString(text[lastByte:offset])
+		lastByte = offset
+		units = append(units, textUnit{byteOffset: offset, charOffset: chars, weight: 1})
+	}
+
+	// Text before the first token, such as leading whitespace, belongs to it
+	if len(units) == 0 {
+		units = append(units, textUnit{weight: 1})
+	}
+	units[0].byteOffset, units[0].charOffset = 0, 0
+
+	chars += utf8.RuneCountInString(text[lastByte:])
+	return append(units, textUnit{byteOffset: len(text), charOffset: chars})
+}
+
+// trimChunk removes leading and trailing whitespace from a chunk, keeping
+// its offsets in step
+func trimChunk(chunk TextChunk) TextChunk {
+	trimmed := strings.TrimLeftFunc(chunk.Text, unicode.IsSpace)
+	chunk.Start += utf8.RuneCountInString(chunk.Text[:len(chunk.Text)-len(trimmed)])
+	chunk.Text = trimmed
+
+	trimmed = strings.TrimRightFunc(chunk.Text, unicode.IsSpace)
+	chunk.End -= utf8.RuneCountInString(chunk.Text[len(trimmed):])
+	chunk.Text = trimmed
+	return chunk
+}
+
+// segmenters split a range of text at progressively finer boundaries
+var segmenters = []func(text string, start, end int) []int{
+	paragraphBoundaries,
+	sentenceBoundaries,
+	wordBoundaries,
+}
+
+// textSpan is the byte range [start, end) of a text
+type textSpan struct {
+	start int
+	end   int
+}
+
+// unitMeasure measures byte ranges of a text in chunk units
+type unitMeasure struct {
+	text    string
+	units   []textUnit
+	lengths []int // lengths[i] is the total weight of the units before units[i]
+}
+
+func newUnitMeasure(text string, units []textUnit) *unitMeasure {
+	lengths := make([]int, len(units))
+	for i := 0; i+1 < len(units); i++ {
+		lengths[i+1] = lengths[i] + units[i].weight
+	}
+	return &unitMeasure{text: text, units: units, lengths: lengths}
+}
+
+// index returns the number of units starting before offset
+func (m *unitMeasure) index(offset int) int {
+	last := len(m.units) - 1
+	return sort.Search(last, func(i int) bool { return m.units[i].byteOffset >= offset })
+}
+
+// length returns the weight of text[start:end]
+func (m *unitMeasure) length(span textSpan) int {
+	return m.lengths[m.index(span.end)] - m.lengths[m.index(span.start)]
+}
+
+// charOffset converts a byte offset into a character offset
+func (m *unitMeasure) charOffset(offset int) int {
+	i := m.index(offset+1) - 1
+	return m.units[i].charOffset + utf8.RuneCountInString(m.text[m.units[i].byteOffset:offset])
+}
+
+// chunkRecursive splits text at paragraphs, then sentences, then words, and
+// only inside a word as a last resort
+func (s *EmbeddingService) chunkRecursive(docID, text string, units []textUnit, opts chunkOptions) []TextChunk {
+	measure := newUnitMeasure(text, units)
+
+	var chunks []TextChunk
+	for _, span := range recursiveSpans(measure, textSpan{0, len(text)}, opts) {
+		chunks = appendChunk(chunks, docID, measure, span)
+	}
+	return chunks
+}
+
+// appendChunk adds the text of span to chunks, trimmed of the whitespace
+// separating it from its neighbours, unless nothing else is left
+func appendChunk(chunks []TextChunk, docID string, measure *unitMeasure, span textSpan) []TextChunk {
+	chunk := trimChunk(TextChunk{
+		Text:  measure.text[span.start:span.end],
+		Start: measure.charOffset(span.start),
+		End:   measure.charOffset(span.end),
+	})
+	if chunk.Text == "" {
+		return chunks
+	}
+	chunk.ChunkID = fmt.Sprintf("%s_%d", docID, len(chunks))
+	return append(chunks, chunk)
+}
+
+// recursiveSpans splits span at paragraphs, then sentences, then words, and
+// only inside a word as a last resort. At each level consecutive segments
+// that fit are packed together, while segments that are too long are split
+// at the next level into spans of their own.
+func recursiveSpans(measure *unitMeasure, span textSpan, opts chunkOptions) []textSpan {
+	var split func(span textSpan, level int) []textSpan
+	split = func(span textSpan, level int) []textSpan {
+		if measure.length(span) <= opts.size {
+			return []textSpan{span}
+		}
+		if level == len(segmenters) {
+			// Cut inside the word at unit boundaries
+			return packSegments(measure, measure.unitSpans(span), opts, nil)
+		}
+
+		segments := splitSpan(span, segmenters[level](measure.text, span.start, span.end))
+		return packSegments(measure, segments, opts, func(segment textSpan) []textSpan {
+			return split(segment, level+1)
+		})
+	}
+	return split(span, 0)
+}
+
+// intactSpans keeps span whole when it fits the model's input limit, even if
+// it is longer than the chunk size, and otherwise splits it at blank lines,
+// then line breaks. It suits code blocks and tables, which lose their
+// meaning when cut.
+func intactSpans(measure *unitMeasure, span textSpan, opts chunkOptions) []textSpan {
+	if measure.length(span) <= opts.limit {
+		return []textSpan{span}
+	}
+	blocks := splitSpan(span, paragraphBoundaries(measure.text, span.start, span.end))
+	return packSegments(measure, blocks, opts, func(block textSpan) []textSpan {
+		lines := splitSpan(block, lineBoundaries(measure.text, block.start, block.end))
+		return packSegments(measure, lines, opts, func(line textSpan) []textSpan {
+			return recursiveSpans(measure, line, opts)
+		})
+	})
+}
+
+// lineBoundaries splits after every line break
+func lineBoundaries(text string, start, end int) []int {
+	var boundaries []int
+	for i := start; i < end-1; i++ {
+		if text[i] == '\n' {
+			boundaries = append(boundaries, i+1)
+		}
+	}
+	return boundaries
+}
+
+// splitSpan cuts span at the given boundaries
+func splitSpan(span textSpan, boundaries []int) []textSpan {
+	segments := make([]textSpan, 0, len(boundaries)+1)
+	start := span.start
+	for _, boundary := range boundaries {
+		segments = append(segments, textSpan{start, boundary})
+		start = boundary
+	}
+	return append(segments, textSpan{start, span.end})
+}
+
+// unitSpans cuts span at every unit boundary
+func (m *unitMeasure) unitSpans(span textSpan) []textSpan {
+	var boundaries []int
+	for i := m.index(span.start + 1); i < len(m.units)-1 && m.units[i].byteOffset < span.end; i++ {
+		boundaries = append(boundaries, m.units[i].byteOffset)
+	}
+	return splitSpan(span, boundaries)
+}
+
+// packSegments packs consecutive segments into spans of at most opts.size,
+// consecutive spans sharing up to opts.overlap. Segments longer than
+// opts.size are handed to splitLong, when set, and its spans kept on their
+// own; otherwise they become a span by themselves.
+func packSegments(measure *unitMeasure, segments []textSpan, opts chunkOptions, splitLong func(textSpan) []textSpan) []textSpan {
+	var spans, current []textSpan
+	currentLength := 0
+
+	flush := func() {
+		if len(current) > 0 {
+			spans = append(spans, textSpan{current[0].start, current[len(current)-1].end})
+		}
+	}
+
+	for _, segment := range segments {
+		segmentLength := measure.length(segment)
+
+		if segmentLength > opts.size {
+			flush()
+			current, currentLength = nil, 0
+			if splitLong != nil {
+				spans = append(spans, splitLong(segment)...)
+			} else {
+				spans = append(spans, segment)
+			}
+			continue
+		}
+
+		if currentLength+segmentLength > opts.size && len(current) > 0 {
+			flush()
+			// Keep trailing segments as overlap while the next one still fits
+			for len(current) > 0 && (currentLength > opts.overlap || currentLength+segmentLength > opts.size) {
+				currentLength -= measure.length(current[0])
+				current = current[1:]
+			}
+		}
+		current = append(current, segment)
+		currentLength += segmentLength
+	}
+	flush()
+	return spans
+}
@@ -0,0 +1,371 @@
+package services
+
+import (
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"unicode/utf8"
+)
+
+// languageExtensions maps source file extensions to the language used for
+// code chunking
+var languageExtensions = map[string]string{
+	".go":    "go",
+	".py":    "python",
+	".js":    "javascript",
+	".jsx":   "javascript",
+	".mjs":   "javascript",
+	".ts":    "typescript",
+	".tsx":   "typescript",
+	".java":  "java",
+	".kt":    "kotlin",
+	".scala": "scala",
+	".c":     "c",
+	".h":     "c",
+	".cc":    "cpp",
+	".cpp":   "cpp",
+	".hpp":   "cpp",
+	".cs":    "csharp",
+	".rs":    "rust",
+	".swift": "swift",
+	".php":   "php",
+	".rb":    "ruby",
+	".sh":    "shell",
+}
+
+// SupportedLanguages lists the values accepted for an input's language
+var SupportedLanguages = []string{
+	"go", "python", "javascript", "typescript", "java", "kotlin", "scala",
+	"c", "cpp", "csharp", "rust", "swift", "php", "ruby", "shell",
+}
+
+// IsSupportedLanguage reports whether language is empty or a known language
+func IsSupportedLanguage(language string) bool {
+	if language == "" {
+		return true
+	}
+	for _, supported := range SupportedLanguages {
+		if language == supported {
+			return true
+		}
+	}
+	return false
+}
+
+// LanguageForFile returns the programming language of filename inferred
+// from its extension, or "" when it is not source code
+func LanguageForFile(filename string) string {
+	return languageExtensions[strings.ToLower(filepath.Ext(filename))]
+}
+
+// indentLanguages delimit blocks by indentation or keywords rather than braces
+var indentLanguages = map[string]bool{"python": true, "ruby": true, "shell": true}
+
+// codeSegment is a top-level declaration together with the comments and
+// blank lines leading up to it
+type codeSegment struct {
+	span    textSpan
+	symbols []string
+}
+
+// chunkCode splits source code at top-level declarations, using go/parser
+// for Go and brace or indentation heuristics for other languages. Small
+// declarations are packed together; a declaration longer than the chunk size
+// stays whole unless it exceeds the model's input limit. Each chunk records
+// the symbols it declares and its line range.
+func (s *EmbeddingService) chunkCode(docID, text string, units []textUnit, opts chunkOptions) []TextChunk {
+	measure := newUnitMeasure(text, units)
+
+	var segments []codeSegment
+	if opts.language == "go" {
+		segments = goSegments(text)
+	}
+	if segments == nil {
+		segments = heuristicSegments(text, indentLanguages[opts.language])
+	}
+
+	spans := make([]textSpan, len(segments))
+	for i, segment := range segments {
+		spans[i] = segment.span
+	}
+	spans = packSegments(measure, spans, opts, func(span textSpan) []textSpan {
+		return intactSpans(measure, span, opts)
+	})
+
+	lines := newLineIndex(text)
+	var chunks []TextChunk
+	next := 0 // first segment that may overlap the current span
+	for _, span := range spans {
+		count := len(chunks)
+		chunks = appendChunk(chunks, docID, measure, span)
+		if len(chunks) == count {
+			continue
+		}
+
+		chunk := &chunks[count]
+		chunk.StartLine = lines.line(chunk.Start)
+		chunk.EndLine = lines.line(chunk.End - 1)
+
+		for next < len(segments) && segments[next].span.end <= span.start {
+			next++
+		}
+		for i := next; i < len(segments) && segments[i].span.start < span.end; i++ {
+			chunk.Symbols = append(chunk.Symbols, segments[i].symbols...)
+		}
+	}
+	return chunks
+}
+
+// goSegments splits Go source at its top-level declarations, or returns nil
+// when it does not parse
+func goSegments(text string) []codeSegment {
+	fset := token.NewFileSet()
+	file, err := parser.ParseFile(fset, "", text, parser.ParseComments|parser.SkipObjectResolution)
+	if err != nil {
+		return nil
+	}
+
+	// The package clause and imports lead the first segment
+	var segments []codeSegment
+	start := 0
+	for _, decl := range file.Decls {
+		if gen, ok := decl.(*ast.GenDecl); ok && gen.Tok == token.IMPORT {
+			continue
+		}
+		end := lineEnd(text, fset.Position(decl.End()).Offset)
+		segments = append(segments, codeSegment{span: textSpan{start, end}, symbols: goSymbols(decl)})
+		start = end
+	}
+
+	if len(segments) == 0 {
+		return []codeSegment{{span: textSpan{0, len(text)}}}
+	}
+	segments[len(segments)-1].span.end = len(text)
+	return segments
+}
+
+// goSymbols returns the names a declaration introduces, with methods
+// qualified by their receiver type as in "Handler.Embed"
+func goSymbols(decl ast.Decl) []string {
+	switch decl := decl.(type) {
+	case *ast.FuncDecl:
+		if decl.Recv == nil || len(decl.Recv.List) == 0 {
+			return []string{decl.Name.Name}
+		}
+		return []string{receiverName(decl.Recv.List[0].Type) + "." + decl.Name.Name}
+	case *ast.GenDecl:
+		var symbols []string
+		for _, spec := range decl.Specs {
+			switch spec := spec.(type) {
+			case *ast.TypeSpec:
+				symbols = append(symbols, spec.Name.Name)
+			case *ast.ValueSpec:
+				for _, name := range spec.Names {
+					if name.Name != "_" {
+						symbols = append(symbols, name.Name)
+					}
+				}
+			}
+		}
+		return symbols
+	}
+	return nil
+}
+
+// receiverName returns the type name of a method receiver
+func receiverName(expr ast.Expr) string {
+	switch expr := expr.(type) {
+	case *ast.StarExpr:
+		return receiverName(expr.X)
+	case *ast.IndexExpr:
+		return receiverName(expr.X)
+	case *ast.IndexListExpr:
+		return receiverName(expr.X)
+	case *ast.Ident:
+		return expr.Name
+	}
+	return ""
+}
+
+// lineEnd returns the offset after the line break ending the line that
+// contains offset
+func lineEnd(text string, offset int) int {
+	if i := strings.IndexByte(text[offset:], '\n'); i >= 0 {
+		return offset + i + 1
+	}
+	return len(text)
+}
+
+// heuristicSegments splits source code at top-level lines that follow a
+// blank line or the end of a block. Blocks are tracked by brace depth,
+// ignoring braces in strings and comments, or by indentation when indented
+// is set.
+func heuristicSegments(text string, indented bool) []codeSegment {
+	var segments []codeSegment
+	start := 0
+	depth := 0
+	scanner := braceScanner{}
+	previousBlank, previousClosed := true, false
+
+	for lineStart := 0; lineStart < len(text); {
+		end := lineEnd(text, lineStart)
+		line := text[lineStart:end]
+		trimmed := strings.TrimSpace(line)
+		blank := trimmed == ""
+
+		topLevel := depth == 0 && !blank && !scanner.inComment && scanner.quote == 0
+		if indented {
+			topLevel = !blank && line[0] != ' ' && line[0] != '\t' && !continuesBlock(trimmed)
+		}
+		if topLevel && lineStart > start && (previousBlank || previousClosed) {
+			segments = append(segments, codeSegment{span: textSpan{start, lineStart}})
+			start = lineStart
+		}
+
+		if indented {
+			// An indented line closes its block once a top-level line follows
+			previousClosed = !blank && (line[0] == ' ' || line[0] == '\t') || (previousClosed && blank)
+		} else {
+			before := depth
+			depth = scanner.scan(line, depth)
+			previousClosed = before > 0 && depth == 0
+		}
+		previousBlank = blank
+		lineStart = end
+	}
+	segments = append(segments, codeSegment{span: textSpan{start, len(text)}})
+
+	for i := range segments {
+		if symbol := codeSymbol(text[segments[i].span.start:segments[i].span.end]); symbol != "" {
+			segments[i].symbols = []string{symbol}
+		}
+	}
+	return segments
+}
+
+// continuesBlock reports whether a top-level line continues the block before
+// it rather than starting a new declaration
+func continuesBlock(trimmed string) bool {
+	for _, keyword := range []string{"else", "elif", "except", "finally", "end", "rescue", "ensure", "fi", "done", "esac", ")", "]", "}"} {
+		if strings.HasPrefix(trimmed, keyword) {
+			rest := trimmed[len(keyword):]
+			if r, _ := utf8.DecodeRuneInString(rest); rest == "" || !isIdentifierRune(r) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func isIdentifierRune(r rune) bool {
+	return r == '_' || r == '$' || r >= '0' && r <= '9' || r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z'
+}
+
+// braceScanner tracks brace depth across lines, skipping string literals
+// and comments
+type braceScanner struct {
+	inComment bool // inside a block comment
+	quote     byte // the quote of a multi-line string literal, or 0
+}
+
+// scan returns the brace depth after line
+func (b *braceScanner) scan(line string, depth int) int {
+	for i := 0; i < len(line); i++ {
+		c := line[i]
+		switch {
+		case b.inComment:
+			if c == '*' && i+1 < len(line) && line[i+1] == '/' {
+				b.inComment = false
+				i++
+			}
+		case b.quote != 0:
+			if c == '\\' {
+				i++
+			} else if c == b.quote {
+				b.quote = 0
+			}
+		case c == '/' && i+1 < len(line) && line[i+1] == '/', c == '#' && i == len(line)-len(strings.TrimLeft(line, " \t")):
+			// Line comments, including # directives and comments
+			return depth
+		case c == '/' && i+1 < len(line) && line[i+1] == '*':
+			b.inComment = true
+			i++
+		case c == '"' || c == '\'' || c == '`':
+			b.quote = c
+		case c == '{':
+			depth++
+		case c == '}':
+			if depth > 0 {
+				depth--
+			}
+		}
+	}
+	// Only template literals span lines; other unterminated quotes are
+	// apostrophes in text such as Rust lifetimes
+	if b.quote != '`' {
+		b.quote = 0
+	}
+	return depth
+}
+
+// symbolPatterns find the name a declaration introduces, tried in order on
+// its first line that is not a comment, decorator or attribute
+var symbolPatterns = []*regexp.Regexp{
+	regexp.MustCompile(`\b(?:class|struct|interface|enum|trait|union|record|object|module|protocol|namespace|impl|type)\s+([A-Za-z_$][\w$]*)`),
+	regexp.MustCompile(`\b(?:func|function|def|fn|fun|sub)\s*\*?\s+([A-Za-z_$][\w$]*)`),
+	regexp.MustCompile(`\b(?:const|let|var|val)\s+([A-Za-z_$][\w$]*)\s*[:=]`),
+	regexp.MustCompile(`^(?:[\w$<>\[\],*&:]+\s+)*\*?([A-Za-z_$][\w$]*)\s*\(`),
+	regexp.MustCompile(`^([A-Za-z_][\w]*)\s*\(\)\s*\{?`),
+}
+
+// codeSymbol returns the name declared by a segment, or ""
+func codeSymbol(segment string) string {
+	for _, line := range strings.Split(segment, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || isCommentLine(line) {
+			continue
+		}
+		for _, pattern := range symbolPatterns {
+			if match := pattern.FindStringSubmatch(line); match != nil {
+				return match[1]
+			}
+		}
+		return ""
+	}
+	return ""
+}
+
+// isCommentLine reports whether a trimmed line is a comment, decorator or
+// attribute
+func isCommentLine(line string) bool {
+	for _, prefix := range []string{"//", "/*", "*", "#", "@", "\"\"\"", "'''"} {
+		if strings.HasPrefix(line, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// lineIndex converts character offsets into 1-based line numbers
+type lineIndex []int // character offset of each line start
+
+func newLineIndex(text string) lineIndex {
+	starts := lineIndex{0}
+	chars := 0
+	for _, r := range text {
+		chars++
+		if r == '\n' {
+			starts = append(starts, chars)
+		}
+	}
+	return starts
+}
+
+// line returns the line containing the character at offset
+func (l lineIndex) line(offset int) int {
+	return sort.SearchInts(l, offset+1)
+}
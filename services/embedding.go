@@ -3,216 +3,607 @@ package services
 import (
 	"batch-embedding-api/config"
 	"batch-embedding-api/models"
-	"bytes"
-	"encoding/json"
+	"context"
+	"crypto/sha256"
+	"errors"
 	"fmt"
-	"io"
 	"log"
 	"math"
-	"math/rand"
-	"net/http"
+	"path/filepath"
 	"strings"
-	"time"
 	"unicode/utf8"
 )
 
+// ErrInputTooLong is returned when a text exceeds the model's token limit
+var ErrInputTooLong = errors.New("input too long")
+
 // EmbeddingService handles all embedding operations
 type EmbeddingService struct {
 	config *config.Config
+	models *ModelRegistry
+	cache  *EmbeddingCache // nil when caching is disabled
+}
+
+// NewEmbeddingService creates a new embedding service serving the configured models
+func NewEmbeddingService(cfg *config.Config) (*EmbeddingService, error) {
+	if cfg.EmbeddingFallback != "none" && cfg.EmbeddingFallback != "mock" {
+		return nil, fmt.Errorf("EMBEDDING_FALLBACK must be 'none' or 'mock', got %q", cfg.EmbeddingFallback)
+	}
+
+	registry, err := NewModelRegistry(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	var cache *EmbeddingCache
+	if cfg.EmbeddingCacheSize > 0 || cfg.EmbeddingCacheDisk {
+		diskPath := ""
+		if cfg.EmbeddingCacheDisk {
+			diskPath = filepath.Join(cfg.StoragePath, "embedding-cache")
+		}
+		cache, err = NewEmbeddingCache(cfg.EmbeddingCacheSize, diskPath)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return &EmbeddingService{config: cfg, models: registry, cache: cache}, nil
 }
 
-// NewEmbeddingService creates a new embedding service
-func NewEmbeddingService(cfg *config.Config) *EmbeddingService {
-	return &EmbeddingService{config: cfg}
+// Models returns the model registry used by the service
+func (s *EmbeddingService) Models() *ModelRegistry {
+	return s.models
 }
 
-// GenerateEmbeddings generates embeddings for the given inputs
-func (s *EmbeddingService) GenerateEmbeddings(req *models.EmbedRequest) (*models.EmbedResponse, error) {
-	results := make([]models.EmbedResult, 0, len(req.Inputs))
+// LookupModel returns the registered model with the given name, or an
+// error wrapping ErrUnknownModel
+func (s *EmbeddingService) LookupModel(name string) (*Model, error) {
+	return s.models.Get(name)
+}
 
-	chunkSize := req.ChunkSize
-	if chunkSize <= 0 {
-		chunkSize = s.config.DefaultChunkSize
+// ProviderHealth returns the circuit breaker state of every provider of every model
+func (s *EmbeddingService) ProviderHealth() []models.ProviderHealth {
+	var health []models.ProviderHealth
+	for _, model := range s.models.List() {
+		for _, status := range model.chain.Status() {
+			entry := models.ProviderHealth{
+				Name:                status.Provider.Name(),
+				Model:               status.Provider.Model(),
+				EmbeddingModel:      model.Name,
+				State:               status.Breaker.State,
+				LastError:           status.Breaker.LastError,
+				ConsecutiveFailures: status.Breaker.ConsecutiveFailures,
+			}
+			if status.Breaker.State != BreakerClosed {
+				entry.OpenedAt = status.Breaker.OpenedAt.Unix()
+			}
+			health = append(health, entry)
+		}
 	}
-	if chunkSize > s.config.MaxChunkSize {
-		chunkSize = s.config.MaxChunkSize
+	return health
+}
+
+// CacheHealth returns the embedding cache counters, or nil when caching is disabled
+func (s *EmbeddingService) CacheHealth() *models.CacheHealth {
+	if s.cache == nil {
+		return nil
 	}
+	stats := s.cache.Stats()
+	return &models.CacheHealth{
+		Entries:  stats.Entries,
+		Hits:     stats.Hits,
+		DiskHits: stats.DiskHits,
+		Misses:   stats.Misses,
+	}
+}
 
-	truncateStrategy := req.TruncateStrategy
-	if truncateStrategy == "" {
-		truncateStrategy = "truncate"
+// GenerateEmbeddings generates embeddings for the given inputs. Upstream
+// calls are abandoned as soon as ctx is done.
+func (s *EmbeddingService) GenerateEmbeddings(ctx context.Context, req *models.EmbedRequest) (*models.EmbedResponse, error) {
+	return s.generateEmbeddings(ctx, req, nil)
+}
+
+// generateEmbeddings generates embeddings for the given inputs, reusing and
+// adding to the vectors of job when it is not nil
+func (s *EmbeddingService) generateEmbeddings(ctx context.Context, req *models.EmbedRequest, job *jobVectors) (*models.EmbedResponse, error) {
+	prepared, err := s.prepareTexts(ctx, req)
+	if err != nil {
+		return nil, err
 	}
+	model, texts, chunksByInput := prepared.model, prepared.texts, prepared.chunksByInput
 
-	for _, input := range req.Inputs {
-		result := models.EmbedResult{ID: input.ID}
+	results := make([]models.EmbedResult, 0, len(req.Inputs))
 
-		textLen := utf8.RuneCountInString(input.Text)
+	normalize := model.Normalize
+	if req.Normalize != nil {
+		normalize = *req.Normalize
+	}
 
-		if textLen <= chunkSize {
-			// No chunking needed
-			embedding := s.generateEmbedding(input.Text, req.Normalize)
-			result.Embeddings = embedding
+	embedded, err := s.embedTexts(ctx, model, texts, normalize, prepared.opts, job)
+	if err != nil {
+		return nil, err
+	}
+
+	next := 0
+	for i, input := range req.Inputs {
+		result := models.EmbedResult{
+			ID:       input.ID,
+			Provider: embedded.provider.Name(),
+			Model:    embedded.provider.Model(),
+		}
+
+		chunks := chunksByInput[i]
+		if chunks == nil {
+			result.Embeddings = embedded.vectors[next]
+			result.Cached = embedded.cached[next]
+			result.PageStart, result.PageEnd = inputPageRange(input)
+			result.Source = input.Source
+			next++
 		} else {
-			// Chunking needed
-			chunks := s.chunkText(input.ID, input.Text, chunkSize, truncateStrategy)
 			result.Chunks = make([]models.Chunk, 0, len(chunks))
-
+			result.Cached = true
 			for _, chunk := range chunks {
-				embedding := s.generateEmbedding(chunk.Text, req.Normalize)
 				result.Chunks = append(result.Chunks, models.Chunk{
 					ChunkID:     chunk.ChunkID,
 					Start:       chunk.Start,
 					End:         chunk.End,
 					TextSnippet: truncateSnippet(chunk.Text, 200),
-					Embedding:   embedding,
+					HeadingPath: chunk.HeadingPath,
+					Symbols:     chunk.Symbols,
+					StartLine:   chunk.StartLine,
+					EndLine:     chunk.EndLine,
+					PageStart:   chunk.PageStart,
+					PageEnd:     chunk.PageEnd,
+					Source:      input.Source,
+					Embedding:   embedded.vectors[next],
+					Cached:      embedded.cached[next],
 				})
+				result.Cached = result.Cached && embedded.cached[next]
+				next++
 			}
 		}
 
 		results = append(results, result)
 	}
 
-	return &models.EmbedResponse{Results: results}, nil
+	return &models.EmbedResponse{
+		Results: results,
+		Dedup: &models.DedupSummary{
+			Texts:  len(texts),
+			Unique: embedded.unique,
+			Saved:  len(texts) - embedded.unique,
+		},
+		Usage: &models.Usage{
+			PromptTokens:  embedded.promptTokens + prepared.sentences.promptTokens,
+			Chunks:        len(texts),
+			UpstreamCalls: embedded.upstreamCalls + prepared.sentences.upstreamCalls,
+		},
+	}, nil
 }
 
-// TextChunk represents a chunk of text
-type TextChunk struct {
-	ChunkID string
-	Text    string
-	Start   int
-	End     int
+// preparedTexts holds the texts a request embeds
+type preparedTexts struct {
+	model         *Model
+	opts          EmbedOptions
+	chunksByInput [][]TextChunk  // nil for inputs embedded whole
+	texts         []string       // the inputs and chunks in order
+	sentences     *embeddedTexts // sentences embedded for semantic chunking
 }
 
-// chunkText splits text into chunks based on strategy
-func (s *EmbeddingService) chunkText(docID, text string, chunkSize int, strategy string) []TextChunk {
-	runes := []rune(text)
-	textLen := len(runes)
+// prepareTexts chunks the inputs of req and collects every text to embed, so
+// the provider can batch them upstream
+func (s *EmbeddingService) prepareTexts(ctx context.Context, req *models.EmbedRequest) (*preparedTexts, error) {
+	model, err := s.models.Get(req.Model)
+	if err != nil {
+		return nil, err
+	}
+
+	chunking := s.resolveChunkOptions(model, req)
+	prepared := &preparedTexts{
+		model:         model,
+		opts:          EmbedOptions{InputType: req.InputType},
+		chunksByInput: make([][]TextChunk, len(req.Inputs)),
+		texts:         make([]string, 0, len(req.Inputs)),
+		sentences:     &embeddedTexts{},
+	}
 
-	if strategy == "truncate" {
-		// Just return first chunk
-		end := chunkSize
-		if end > textLen {
-			end = textLen
+	// With split_at_pages every page of a PDF is chunked as an input of its own
+	inputs := req.Inputs
+	var pages []inputPage
+	if req.SplitAtPages {
+		inputs, pages = splitPages(req.Inputs)
+	}
+
+	// Semantic chunking embeds the sentences of long inputs up front
+	var semanticChunks [][]TextChunk
+	if chunking.strategy == "semantic" {
+		semanticChunks, prepared.sentences, err = s.chunkSemantic(ctx, model, inputs, chunking, prepared.opts)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	chunksByInput := make([][]TextChunk, len(inputs))
+	for i, input := range inputs {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+
+		if !chunking.structural() && chunking.length(input.Text) <= chunking.size {
+			// No chunking needed
+			continue
+		}
+
+		// Chunking needed
+		if semanticChunks != nil {
+			chunksByInput[i] = semanticChunks[i]
+			continue
 		}
-		return []TextChunk{{
-			ChunkID: fmt.Sprintf("%s_0", docID),
-			Text:    string(runes[:end]),
-			Start:   0,
-			End:     end,
-		}}
-	}
-
-	// Split strategy - split into multiple chunks
-	var chunks []TextChunk
-	chunkIndex := 0
-
-	for start := 0; start < textLen; start += chunkSize {
-		end := start + chunkSize
-		if end > textLen {
-			end = textLen
+		inputChunking := chunking
+		if input.Language != "" {
+			inputChunking.language = input.Language
 		}
+		chunksByInput[i] = s.chunkText(input.ID, input.Text, inputChunking)
+	}
+	if pages != nil {
+		chunksByInput = joinPages(req.Inputs, inputs, pages, chunksByInput)
+	}
 
-		chunks = append(chunks, TextChunk{
-			ChunkID: fmt.Sprintf("%s_%d", docID, chunkIndex),
-			Text:    string(runes[start:end]),
-			Start:   start,
-			End:     end,
-		})
-		chunkIndex++
+	for i, input := range req.Inputs {
+		chunks := chunksByInput[i]
+		if len(chunks) == 0 {
+			// Inputs that fit, or only whitespace, are embedded as is
+			prepared.texts = append(prepared.texts, input.Text)
+			continue
+		}
+		setPageRanges(chunks, input.Pages)
+		prepared.chunksByInput[i] = chunks
+		for _, chunk := range chunks {
+			prepared.texts = append(prepared.texts, chunkEmbedText(chunk, req.PrependHeadingPath))
+		}
 	}
 
-	return chunks
+	if err := checkTokenLimit(model, req.Inputs, prepared.chunksByInput, prepared.texts, prepared.opts); err != nil {
+		return nil, err
+	}
+	return prepared, nil
 }
 
-// generateEmbedding generates embedding for text
-func (s *EmbeddingService) generateEmbedding(text string, normalize bool) []float32 {
-	dimension := s.config.EmbeddingDimension
+// chunkEmbedText returns the text embedded for chunk, led by its heading
+// path when prependHeadingPath is set
+func chunkEmbedText(chunk TextChunk, prependHeadingPath bool) string {
+	if !prependHeadingPath || chunk.HeadingPath == "" {
+		return chunk.Text
+	}
+	return chunk.HeadingPath + "\n\n" + chunk.Text
+}
 
-	switch s.config.EmbeddingProvider {
-	case "ollama":
-		emb, err := s.ollamaEmbedding(text)
-		if err != nil {
-			log.Printf("Ollama embedding failed: %v, falling back to mock", err)
-			return s.mockEmbedding(text, dimension, normalize)
+// checkTokenLimit rejects texts that the model's tokenizer counts above its
+// max_input_tokens, naming the offending input or chunk. texts holds the
+// inputs and chunks in the order GenerateEmbeddings collected them.
+func checkTokenLimit(model *Model, inputs []models.InputItem, chunksByInput [][]TextChunk, texts []string, opts EmbedOptions) error {
+	if model.MaxInputTokens <= 0 {
+		return nil
+	}
+
+	prefix := model.InputPrefixes[opts.InputType]
+	next := 0
+	for i, input := range inputs {
+		ids := []string{input.ID}
+		if chunksByInput[i] != nil {
+			ids = ids[:0]
+			for _, chunk := range chunksByInput[i] {
+				ids = append(ids, chunk.ChunkID)
+			}
 		}
-		if normalize {
-			emb = normalizeL2(emb)
+
+		for _, id := range ids {
+			if tokens := model.Tokenizer.Count(prefix + texts[next]); tokens > model.MaxInputTokens {
+				return fmt.Errorf("%w: %s has %d tokens, model %s accepts at most %d; reduce chunk_size",
+					ErrInputTooLong, id, tokens, model.Name, model.MaxInputTokens)
+			}
+			next++
 		}
-		return emb
-	case "openai":
-		// TODO: Implement OpenAI embedding
-		return s.mockEmbedding(text, dimension, normalize)
-	case "mock":
-		fallthrough
-	default:
-		return s.mockEmbedding(text, dimension, normalize)
 	}
+	return nil
 }
 
-// OllamaEmbedRequest represents the request to Ollama API
-type OllamaEmbedRequest struct {
-	Model  string `json:"model"`
-	Prompt string `json:"prompt"`
+// embeddedTexts holds one vector per text and how the vectors were obtained
+type embeddedTexts struct {
+	vectors  [][]float32
+	cached   []bool
+	provider EmbeddingProvider
+	unique   int // distinct texts among the inputs
+
+	promptTokens  int // tokens sent upstream
+	upstreamCalls int
+
+	job *jobVectors // nil outside async jobs
 }
 
-// OllamaEmbedResponse represents the response from Ollama API
-type OllamaEmbedResponse struct {
-	Embedding []float32 `json:"embedding"`
+// jobVectors holds the vectors an async job has embedded so far, so texts
+// repeated across its files are embedded once even without the embedding
+// cache, and counts the job's texts for its dedup summary
+type jobVectors struct {
+	vectors map[string][]float32 // by embeddingCacheKey
+	seen    map[[sha256.Size]byte]struct{}
+	texts   int
 }
 
-// ollamaEmbedding calls Ollama API to generate embeddings
-func (s *EmbeddingService) ollamaEmbedding(text string) ([]float32, error) {
-	reqBody := OllamaEmbedRequest{
-		Model:  s.config.OllamaModel,
-		Prompt: text,
+// newJobVectors creates an empty set of job vectors
+func newJobVectors() *jobVectors {
+	return &jobVectors{
+		vectors: make(map[string][]float32),
+		seen:    make(map[[sha256.Size]byte]struct{}),
 	}
+}
 
-	jsonData, err := json.Marshal(reqBody)
-	if err != nil {
-		return nil, fmt.Errorf("failed to marshal request: %w", err)
+// get returns the vector stored under key
+func (j *jobVectors) get(key string) ([]float32, bool) {
+	if j == nil {
+		return nil, false
+	}
+	vector, ok := j.vectors[key]
+	return vector, ok
+}
+
+// put stores a vector under key
+func (j *jobVectors) put(key string, vector []float32) {
+	if j != nil {
+		j.vectors[key] = vector
+	}
+}
+
+// dedup returns the job-wide dedup summary
+func (j *jobVectors) dedup() *models.DedupSummary {
+	return &models.DedupSummary{
+		Texts:  j.texts,
+		Unique: len(j.seen),
+		Saved:  j.texts - len(j.seen),
+	}
+}
+
+// embedTexts embeds texts, collapsing identical texts so each is embedded
+// once and fanning the vectors back out to every position. Texts already
+// embedded by job are not sent again.
+func (s *EmbeddingService) embedTexts(ctx context.Context, model *Model, texts []string, normalize bool, opts EmbedOptions, job *jobVectors) (*embeddedTexts, error) {
+	positions := make([]int, len(texts))
+	indexByText := make(map[string]int, len(texts))
+	uniqueTexts := make([]string, 0, len(texts))
+	for i, text := range texts {
+		index, exists := indexByText[text]
+		if !exists {
+			index = len(uniqueTexts)
+			indexByText[text] = index
+			uniqueTexts = append(uniqueTexts, text)
+		}
+		positions[i] = index
+	}
+	if job != nil {
+		job.texts += len(texts)
+		for _, text := range uniqueTexts {
+			job.seen[sha256.Sum256([]byte(text))] = struct{}{}
+		}
+	}
+
+	embedded, err := s.embedUniqueTexts(ctx, model, uniqueTexts, normalize, opts, job)
+	if err != nil || len(uniqueTexts) == len(texts) {
+		return embedded, err
+	}
+
+	result := &embeddedTexts{
+		vectors:  make([][]float32, len(texts)),
+		cached:   make([]bool, len(texts)),
+		provider: embedded.provider,
+		unique:   embedded.unique,
+
+		promptTokens:  embedded.promptTokens,
+		upstreamCalls: embedded.upstreamCalls,
+	}
+	for i, index := range positions {
+		result.vectors[i] = embedded.vectors[index]
+		result.cached[i] = embedded.cached[index]
+	}
+	return result, nil
+}
+
+// embedUniqueTexts embeds distinct texts, serving repeated texts from the
+// job's vectors or the embedding cache and sending the misses through the
+// model's provider chain once. All vectors of a request come from a single provider so they are
+// comparable: cached vectors are keyed on the primary provider, and when a
+// fallback serves the misses the hits are looked up under the fallback
+// instead and, failing that, embedded by the fallback alone. Vectors of the
+// mock fallback are never cached.
+func (s *EmbeddingService) embedUniqueTexts(ctx context.Context, model *Model, texts []string, normalize bool, opts EmbedOptions, job *jobVectors) (*embeddedTexts, error) {
+	result := &embeddedTexts{
+		vectors:  make([][]float32, len(texts)),
+		cached:   make([]bool, len(texts)),
+		provider: model.Primary(),
+		unique:   len(texts),
+		job:      job,
 	}
 
-	url := fmt.Sprintf("%s/api/embeddings", s.config.OllamaURL)
+	missing := s.lookupCache(model, result, texts, nil, normalize, opts)
+	if len(missing) == 0 {
+		return result, nil
+	}
 
-	client := &http.Client{Timeout: 60 * time.Second}
-	resp, err := client.Post(url, "application/json", bytes.NewBuffer(jsonData))
+	sent, err := s.embedUpstream(ctx, model, nil, selectTexts(texts, missing), normalize, opts)
 	if err != nil {
-		return nil, fmt.Errorf("failed to call Ollama: %w", err)
+		return nil, err
+	}
+	if err := s.storeVectors(model, result, sent, texts, missing, normalize, opts); err != nil {
+		return nil, err
 	}
-	defer resp.Body.Close()
 
-	if resp.StatusCode != http.StatusOK {
-		body, _ := io.ReadAll(resp.Body)
-		return nil, fmt.Errorf("Ollama returned status %d: %s", resp.StatusCode, string(body))
+	if sent.Provider != model.Primary() && len(missing) < len(texts) {
+		// The hits came from another provider than the misses
+		var hits []int
+		for i := range texts {
+			if result.cached[i] {
+				hits = append(hits, i)
+			}
+		}
+		stale := hits
+		if !sent.fallback {
+			stale = s.lookupCache(model, result, texts, hits, normalize, opts)
+		}
+		if len(stale) > 0 {
+			resent, err := s.embedUpstream(ctx, model, sent, selectTexts(texts, stale), normalize, opts)
+			if err != nil {
+				return nil, err
+			}
+			if err := s.storeVectors(model, result, resent, texts, stale, normalize, opts); err != nil {
+				return nil, err
+			}
+			if resent.fallback && !sent.fallback {
+				// The fallback failed in turn; the mock replaces its vectors too
+				resent, err = s.embedUpstream(ctx, model, resent, selectTexts(texts, missing), normalize, opts)
+				if err != nil {
+					return nil, err
+				}
+				if err := s.storeVectors(model, result, resent, texts, missing, normalize, opts); err != nil {
+					return nil, err
+				}
+			}
+		}
+	}
+	return result, nil
+}
+
+// lookupCache fills result with the vectors of the texts at indexes, or of
+// all texts when indexes is nil, found in result.job or the cache under
+// result.provider. It returns the indexes of the texts not found.
+func (s *EmbeddingService) lookupCache(model *Model, result *embeddedTexts, texts []string, indexes []int, normalize bool, opts EmbedOptions) []int {
+	if indexes == nil {
+		indexes = make([]int, len(texts))
+		for i := range texts {
+			indexes[i] = i
+		}
 	}
 
-	var ollamaResp OllamaEmbedResponse
-	if err := json.NewDecoder(resp.Body).Decode(&ollamaResp); err != nil {
-		return nil, fmt.Errorf("failed to decode Ollama response: %w", err)
+	missing := make([]int, 0, len(indexes))
+	for _, i := range indexes {
+		result.cached[i] = false
+		if s.cache != nil || result.job != nil {
+			key := embeddingCacheKey(model.Name, result.provider, opts.InputType, normalize, texts[i])
+			vector, ok := result.job.get(key)
+			if !ok && s.cache != nil {
+				vector, ok = s.cache.Get(key, result.provider.Dimension())
+			}
+			if ok {
+				result.vectors[i] = vector
+				result.cached[i] = true
+				continue
+			}
+		}
+		missing = append(missing, i)
+	}
+	return missing
+}
+
+// storeVectors puts the vectors sent upstream for the texts at indexes into
+// result and, unless they came from the mock fallback, into result.job and
+// the cache. It
+// fails when sent does not hold one vector per index.
+func (s *EmbeddingService) storeVectors(model *Model, result *embeddedTexts, sent *upstreamEmbeddings, texts []string, indexes []int, normalize bool, opts EmbedOptions) error {
+	if len(sent.Embeddings) != len(indexes) {
+		return newMalformedError(sent.Provider.Name(), "returned %d embeddings for %d inputs", len(sent.Embeddings), len(indexes))
+	}
+	result.provider = sent.Provider
+	result.promptTokens += sent.promptTokens
+	result.upstreamCalls += sent.UpstreamCalls
+	for j, i := range indexes {
+		result.vectors[i] = sent.Embeddings[j]
+		result.cached[i] = false
+		if (s.cache != nil || result.job != nil) && !sent.fallback {
+			key := embeddingCacheKey(model.Name, sent.Provider, opts.InputType, normalize, texts[i])
+			result.job.put(key, sent.Embeddings[j])
+			if s.cache != nil {
+				s.cache.Put(key, sent.Embeddings[j])
+			}
+		}
+	}
+	return nil
+}
+
+// selectTexts returns the texts at the given indexes
+func selectTexts(texts []string, indexes []int) []string {
+	selected := make([]string, len(indexes))
+	for j, i := range indexes {
+		selected[j] = texts[i]
 	}
+	return selected
+}
 
-	return ollamaResp.Embedding, nil
+// upstreamEmbeddings is the outcome of one embedUpstream call
+type upstreamEmbeddings struct {
+	ChainResult
+	promptTokens int
+	fallback     bool // produced by the mock fallback
 }
 
-// mockEmbedding generates a deterministic mock embedding based on text
-func (s *EmbeddingService) mockEmbedding(text string, dimension int, normalize bool) []float32 {
-	// Use text hash as seed for reproducibility
-	seed := int64(0)
-	for _, r := range text {
-		seed = seed*31 + int64(r)
+// embedUpstream embeds texts through the model's provider chain and returns
+// the provider that produced them. When like is set, the texts are embedded
+// by the provider of an earlier call alone, so the vectors match its own.
+// Provider failures are returned to the caller unless EMBEDDING_FALLBACK=mock
+// is configured.
+func (s *EmbeddingService) embedUpstream(ctx context.Context, model *Model, like *upstreamEmbeddings, texts []string, normalize bool, opts EmbedOptions) (*upstreamEmbeddings, error) {
+	// Models such as E5 and nomic expect an instruction prefix per input type
+	if prefix := model.InputPrefixes[opts.InputType]; prefix != "" {
+		prefixed := make([]string, len(texts))
+		for i, text := range texts {
+			prefixed[i] = prefix + text
+		}
+		texts = prefixed
 	}
-	rng := rand.New(rand.NewSource(seed))
 
-	embedding := make([]float32, dimension)
-	for i := range embedding {
-		embedding[i] = rng.Float32()*2 - 1 // Range [-1, 1]
+	sent := &upstreamEmbeddings{}
+	for _, text := range texts {
+		sent.promptTokens += model.Tokenizer.Count(text)
+	}
+
+	var chainResult *ChainResult
+	var err error
+	switch {
+	case like == nil:
+		chainResult, err = model.chain.Embed(ctx, texts, opts)
+	case like.fallback:
+		if chainResult, err = mockEmbeddings(ctx, like.Provider, texts, opts); err != nil {
+			return nil, err
+		}
+		sent.fallback = true
+	default:
+		chainResult, err = model.chain.EmbedWith(ctx, like.Provider, texts, opts)
+	}
+	if err != nil {
+		if s.config.EmbeddingFallback != "mock" || ctx.Err() != nil {
+			return nil, err
+		}
+		log.Printf("Embedding failed: %v, falling back to mock (EMBEDDING_FALLBACK=mock)", err)
+		provider := &MockProvider{dimension: model.Dimension, maxInputSize: model.MaxInputLength}
+		if chainResult, err = mockEmbeddings(ctx, provider, texts, opts); err != nil {
+			return nil, err
+		}
+		sent.fallback = true
 	}
+	sent.ChainResult = *chainResult
 
 	if normalize {
-		embedding = normalizeL2(embedding)
+		for i, emb := range sent.Embeddings {
+			sent.Embeddings[i] = normalizeL2(emb)
+		}
 	}
+	return sent, nil
+}
 
-	return embedding
+// mockEmbeddings embeds texts locally with the mock fallback provider
+func mockEmbeddings(ctx context.Context, provider EmbeddingProvider, texts []string, opts EmbedOptions) (*ChainResult, error) {
+	embeddings, err := provider.Embed(ctx, texts, opts)
+	if err != nil {
+		return nil, err
+	}
+	return &ChainResult{Embeddings: embeddings, Provider: provider}, nil
 }
 
 // normalizeL2 applies L2 normalization to a vector
@@ -243,81 +634,54 @@ func truncateSnippet(text string, maxLen int) string {
 	return string(runes[:maxLen]) + "..."
 }
 
-// ExtractTextFromFile extracts text from file bytes
-func (s *EmbeddingService) ExtractTextFromFile(filename string, content []byte) (string, error) {
-	lowerName := strings.ToLower(filename)
+// ExtractedText is the text of an uploaded or downloaded file
+type ExtractedText struct {
+	Text     string
+	Language string // programming language inferred from the extension, empty for documents
+	Pages    []int  // character offset of each page of a PDF, empty for other files
+}
 
-	if strings.HasSuffix(lowerName, ".txt") {
-		return string(content), nil
+// IsSupportedFile reports whether ExtractTextFromFile accepts filename
+func IsSupportedFile(filename string) bool {
+	switch strings.ToLower(filepath.Ext(filename)) {
+	case ".txt", ".md", ".markdown", ".pdf":
+		return true
 	}
-
-	if strings.HasSuffix(lowerName, ".pdf") {
-		// Simple PDF text extraction (basic implementation)
-		// For production, use a proper PDF library
-		text := extractPDFText(content)
-		if text == "" {
-			return "", fmt.Errorf("could not extract text from PDF")
-		}
-		return text, nil
-	}
-
-	return "", fmt.Errorf("unsupported file type: %s", filename)
+	return LanguageForFile(filename) != ""
 }
 
-// extractPDFText is a basic PDF text extractor
-// For production, use github.com/ledongthuc/pdf or similar
-func extractPDFText(content []byte) string {
-	// This is a very basic implementation
-	// It looks for text between "stream" and "endstream" markers
-	// For real PDF parsing, use a proper library
-
-	text := string(content)
+// ExtractTextFromFile extracts text from file bytes
+func (s *EmbeddingService) ExtractTextFromFile(ctx context.Context, filename string, content []byte) (*ExtractedText, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
 
-	// Try to find readable text (very basic approach)
-	var result strings.Builder
-	inText := false
+	lowerName := strings.ToLower(filename)
 
-	for i := 0; i < len(text); i++ {
-		c := text[i]
+	if strings.HasSuffix(lowerName, ".txt") || strings.HasSuffix(lowerName, ".md") || strings.HasSuffix(lowerName, ".markdown") {
+		return &ExtractedText{Text: string(content)}, nil
+	}
 
-		// Look for BT (Begin Text) and ET (End Text) markers
-		if i < len(text)-1 {
-			if text[i] == 'B' && text[i+1] == 'T' {
-				inText = true
-				continue
-			}
-			if text[i] == 'E' && text[i+1] == 'T' {
-				inText = false
-				result.WriteRune(' ')
-				continue
-			}
-		}
+	if language := LanguageForFile(filename); language != "" {
+		return &ExtractedText{Text: string(content), Language: language}, nil
+	}
 
-		if inText {
-			// Extract text between parentheses
-			if c == '(' {
-				j := i + 1
-				for j < len(text) && text[j] != ')' {
-					if text[j] >= 32 && text[j] < 127 {
-						result.WriteByte(text[j])
-					}
-					j++
-				}
-				i = j
-			}
+	if strings.HasSuffix(lowerName, ".pdf") {
+		pages, err := extractPDFPages(ctx, content)
+		if err != nil {
+			return nil, err
 		}
-	}
 
-	// Fallback: if no text found, try to extract any readable ASCII
-	if result.Len() == 0 {
-		for _, c := range content {
-			if c >= 32 && c < 127 {
-				result.WriteByte(c)
-			} else if c == '\n' || c == '\r' || c == '\t' {
-				result.WriteByte(' ')
-			}
+		// Pages are separated by a blank line
+		extracted := &ExtractedText{Pages: make([]int, len(pages))}
+		offset := 0
+		for i, page := range pages {
+			extracted.Pages[i] = offset
+			offset += utf8.RuneCountInString(page) + 2
 		}
+		extracted.Text = strings.Join(pages, "\n\n")
+		return extracted, nil
 	}
 
-	return strings.TrimSpace(result.String())
+	return nil, fmt.Errorf("unsupported file type: %s", filename)
 }
@@ -0,0 +1,66 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+)
+
+// Error codes reported when an embedding provider fails
+const (
+	ErrCodeUpstreamTimeout     = "upstream_timeout"
+	ErrCodeUpstreamClientError = "upstream_client_error"
+	ErrCodeUpstreamServerError = "upstream_server_error"
+	ErrCodeMalformedResponse   = "malformed_response"
+	ErrCodeProviderUnavailable = "provider_unavailable"
+)
+
+// ProviderError describes a failure of an upstream embedding provider
+type ProviderError struct {
+	Code       string
+	Provider   string
+	StatusCode int // upstream HTTP status, 0 when no response was received
+	Message    string
+	Err        error
+	Throttled  bool // raised by our own rate limiting without calling upstream
+}
+
+func (e *ProviderError) Error() string {
+	if e.StatusCode != 0 {
+		return fmt.Sprintf("%s provider: %s (upstream status %d)", e.Provider, e.Message, e.StatusCode)
+	}
+	return fmt.Sprintf("%s provider: %s", e.Provider, e.Message)
+}
+
+func (e *ProviderError) Unwrap() error {
+	return e.Err
+}
+
+// newTransportError classifies an error returned by an HTTP client call
+func newTransportError(provider string, err error) *ProviderError {
+	var netErr net.Error
+	if errors.Is(err, context.DeadlineExceeded) || (errors.As(err, &netErr) && netErr.Timeout()) {
+		return &ProviderError{Code: ErrCodeUpstreamTimeout, Provider: provider, Message: "request timed out", Err: err}
+	}
+	return &ProviderError{Code: ErrCodeUpstreamServerError, Provider: provider, Message: err.Error(), Err: err}
+}
+
+// newStatusError classifies a non-2xx upstream response
+func newStatusError(provider string, statusCode int, message string) *ProviderError {
+	code := ErrCodeUpstreamServerError
+	switch {
+	case statusCode == http.StatusRequestTimeout || statusCode == http.StatusGatewayTimeout:
+		code = ErrCodeUpstreamTimeout
+	case statusCode >= 400 && statusCode < 500:
+		code = ErrCodeUpstreamClientError
+	}
+	return &ProviderError{Code: code, Provider: provider, StatusCode: statusCode, Message: message}
+}
+
+// newMalformedError reports an upstream response that could not be used
+func newMalformedError(provider string, format string, args ...interface{}) *ProviderError {
+	err := fmt.Errorf(format, args...)
+	return &ProviderError{Code: ErrCodeMalformedResponse, Provider: provider, Message: err.Error(), Err: errors.Unwrap(err)}
+}
@@ -21,20 +21,31 @@ func NewJobStore() *JobStore {
 	}
 }
 
-// CreateJob creates a new job
-func (s *JobStore) CreateJob(files []string, model, callbackURL string) *models.Job {
+// CreateJob creates a new job from an async job request
+func (s *JobStore) CreateJob(req *models.AsyncJobRequest) *models.Job {
 	s.mutex.Lock()
 	defer s.mutex.Unlock()
 
 	job := &models.Job{
-		JobID:       uuid.New().String(),
-		Status:      "queued",
-		Progress:    0,
-		Files:       files,
-		Model:       model,
-		CallbackURL: callbackURL,
-		CreatedAt:   time.Now().Unix(),
-		UpdatedAt:   time.Now().Unix(),
+		JobID:                uuid.New().String(),
+		Status:               "queued",
+		Progress:             0,
+		Files:                req.Files,
+		Model:                req.Model,
+		CallbackURL:          req.CallbackURL,
+		TruncateStrategy:     req.TruncateStrategy,
+		ChunkSize:            req.ChunkSize,
+		ChunkUnit:            req.ChunkUnit,
+		ChunkOverlap:         req.ChunkOverlap,
+		MinChunkSize:         req.MinChunkSize,
+		BreakpointPercentile: req.BreakpointPercentile,
+		SplitAtPages:         req.SplitAtPages,
+		Normalize:            req.Normalize,
+		PrependHeadingPath:   req.PrependHeadingPath,
+		InputType:            req.InputType,
+		TimeoutMs:            req.TimeoutMs,
+		CreatedAt:            time.Now().Unix(),
+		UpdatedAt:            time.Now().Unix(),
 	}
 
 	s.jobs[job.JobID] = job
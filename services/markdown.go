@@ -0,0 +1,232 @@
+package services
+
+import (
+	"regexp"
+	"strings"
+)
+
+// HeadingPathSeparator joins the headings enclosing a Markdown chunk
+const HeadingPathSeparator = " > "
+
+// Kinds of Markdown block
+const (
+	markdownText = iota
+	markdownHeading
+	markdownCode
+	markdownTable
+)
+
+// markdownBlock is a heading, fenced code block, table or paragraph
+type markdownBlock struct {
+	kind  int
+	span  textSpan
+	level int    // heading level, 1 to 6
+	title string // heading text
+}
+
+var (
+	atxHeadingPattern      = regexp.MustCompile(`^ {0,3}(#{1,6})(?:[ \t]+(.*?))??(?:[ \t]+#+)?[ \t]*$`)
+	setextUnderlinePattern = regexp.MustCompile(`^ {0,3}(=+|-+)[ \t]*$`)
+	fenceOpenPattern       = regexp.MustCompile("^ {0,3}(`{3,}|~{3,})")
+	tableSeparatorPattern  = regexp.MustCompile(`^ {0,3}\|?[ \t]*:?-+:?[ \t]*(\|[ \t]*:?-+:?[ \t]*)*\|?[ \t]*$`)
+)
+
+// markdownLine is a line of text without its line ending
+type markdownLine struct {
+	text  string
+	start int // byte offset of the line
+	end   int // byte offset after the line ending
+}
+
+// markdownLines splits text into lines
+func markdownLines(text string) []markdownLine {
+	var lines []markdownLine
+	for start := 0; start < len(text); {
+		end := strings.IndexByte(text[start:], '\n')
+		if end < 0 {
+			end = len(text)
+		} else {
+			end += start + 1
+		}
+		line := strings.TrimRight(text[start:end], "\r\n")
+		lines = append(lines, markdownLine{text: line, start: start, end: end})
+		start = end
+	}
+	return lines
+}
+
+// parseMarkdown splits text into blocks. Headings inside code blocks are
+// ignored and an unclosed code block runs to the end of the text.
+func parseMarkdown(text string) []markdownBlock {
+	lines := markdownLines(text)
+	var blocks []markdownBlock
+
+	// Consecutive lines of other text form one block, split into paragraphs
+	// when packed
+	textStart := -1
+	flushText := func(end int) {
+		if textStart >= 0 && end > textStart {
+			blocks = append(blocks, markdownBlock{kind: markdownText, span: textSpan{textStart, end}})
+		}
+		textStart = -1
+	}
+
+	for i := 0; i < len(lines); i++ {
+		line := lines[i]
+
+		if match := atxHeadingPattern.FindStringSubmatch(line.text); match != nil {
+			flushText(line.start)
+			blocks = append(blocks, markdownBlock{
+				kind:  markdownHeading,
+				span:  textSpan{line.start, line.end},
+				level: len(match[1]),
+				title: strings.TrimSpace(match[2]),
+			})
+			continue
+		}
+
+		if match := fenceOpenPattern.FindStringSubmatch(line.text); match != nil {
+			flushText(line.start)
+			end := len(lines) - 1
+			for j := i + 1; j < len(lines); j++ {
+				if closesFence(lines[j].text, match[1]) {
+					end = j
+					break
+				}
+			}
+			blocks = append(blocks, markdownBlock{kind: markdownCode, span: textSpan{line.start, lines[end].end}})
+			i = end
+			continue
+		}
+
+		if i+1 < len(lines) && strings.Contains(line.text, "|") && tableSeparatorPattern.MatchString(lines[i+1].text) {
+			flushText(line.start)
+			end := i + 1
+			for end+1 < len(lines) && strings.Contains(lines[end+1].text, "|") && strings.TrimSpace(lines[end+1].text) != "" {
+				end++
+			}
+			blocks = append(blocks, markdownBlock{kind: markdownTable, span: textSpan{line.start, lines[end].end}})
+			i = end
+			continue
+		}
+
+		// A setext heading is a single line of text underlined with = or -;
+		// after a blank line a row of dashes is a thematic break instead
+		startsParagraph := i == 0 || strings.TrimSpace(lines[i-1].text) == ""
+		if startsParagraph && strings.TrimSpace(line.text) != "" && i+1 < len(lines) {
+			if match := setextUnderlinePattern.FindStringSubmatch(lines[i+1].text); match != nil {
+				flushText(line.start)
+				level := 1
+				if match[1][0] == '-' {
+					level = 2
+				}
+				blocks = append(blocks, markdownBlock{
+					kind:  markdownHeading,
+					span:  textSpan{line.start, lines[i+1].end},
+					level: level,
+					title: strings.TrimSpace(line.text),
+				})
+				i++
+				continue
+			}
+		}
+
+		if textStart < 0 {
+			textStart = line.start
+		}
+	}
+	flushText(len(text))
+	return blocks
+}
+
+// closesFence reports whether line closes a code block opened with fence
+func closesFence(line, fence string) bool {
+	trimmed := strings.TrimLeft(line, " ")
+	if len(line)-len(trimmed) > 3 {
+		return false
+	}
+	run := len(trimmed) - len(strings.TrimLeft(trimmed, fence[:1]))
+	return run >= len(fence) && strings.TrimSpace(trimmed[run:]) == ""
+}
+
+// markdownSection is the content under a heading, up to the next heading
+type markdownSection struct {
+	path   []string
+	blocks []markdownBlock
+}
+
+// markdownSections groups blocks under the heading they follow, recording
+// the titles of the enclosing headings
+func markdownSections(blocks []markdownBlock) []markdownSection {
+	var sections []markdownSection
+	var headings []markdownBlock
+	current := markdownSection{}
+
+	for _, block := range blocks {
+		if block.kind != markdownHeading {
+			current.blocks = append(current.blocks, block)
+			continue
+		}
+
+		if len(current.blocks) > 0 {
+			sections = append(sections, current)
+		}
+		for len(headings) > 0 && headings[len(headings)-1].level >= block.level {
+			headings = headings[:len(headings)-1]
+		}
+		headings = append(headings, block)
+
+		path := make([]string, 0, len(headings))
+		for _, heading := range headings {
+			if heading.title != "" {
+				path = append(path, heading.title)
+			}
+		}
+		current = markdownSection{path: path}
+	}
+	if len(current.blocks) > 0 {
+		sections = append(sections, current)
+	}
+	return sections
+}
+
+// chunkMarkdown splits Markdown at its headings so that no chunk spans two
+// sections. Within a section, paragraphs, code blocks and tables are packed
+// like recursive chunks, but code blocks and tables are only split when they
+// exceed the model's input limit, and then at blank lines and line breaks.
+// Heading lines are left out of the chunks and recorded as each chunk's
+// heading path instead.
+func (s *EmbeddingService) chunkMarkdown(docID, text string, units []textUnit, opts chunkOptions) []TextChunk {
+	measure := newUnitMeasure(text, units)
+
+	var chunks []TextChunk
+	for _, section := range markdownSections(parseMarkdown(text)) {
+		var segments []textSpan
+		intact := make(map[textSpan]bool)
+		for _, block := range section.blocks {
+			if block.kind == markdownText {
+				segments = append(segments, splitSpan(block.span, paragraphBoundaries(text, block.span.start, block.span.end))...)
+				continue
+			}
+			segments = append(segments, block.span)
+			intact[block.span] = true
+		}
+
+		spans := packSegments(measure, segments, opts, func(segment textSpan) []textSpan {
+			if intact[segment] {
+				return intactSpans(measure, segment, opts)
+			}
+			return recursiveSpans(measure, segment, opts)
+		})
+
+		path := strings.Join(section.path, HeadingPathSeparator)
+		for _, span := range spans {
+			count := len(chunks)
+			chunks = appendChunk(chunks, docID, measure, span)
+			if len(chunks) > count {
+				chunks[count].HeadingPath = path
+			}
+		}
+	}
+	return chunks
+}
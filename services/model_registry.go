@@ -0,0 +1,157 @@
+package services
+
+import (
+	"batch-embedding-api/config"
+	"errors"
+	"fmt"
+	"log"
+)
+
+// ErrUnknownModel is returned when a request names a model that is not registered
+var ErrUnknownModel = errors.New("unknown model")
+
+// Model availability states
+const (
+	ModelAvailable   = "available"
+	ModelDegraded    = "degraded"
+	ModelUnavailable = "unavailable"
+)
+
+// Model is a public embedding model together with the providers serving it
+type Model struct {
+	Name           string
+	Dimension      int
+	MaxInputLength int
+	MaxInputTokens int // 0 when the model has no token limit
+	Normalize      bool
+	InputPrefixes  map[string]string // input_type → text prepended before embedding
+	Tokenizer      Tokenizer
+	chain          *ProviderChain
+}
+
+// Primary returns the provider normally serving the model
+func (m *Model) Primary() EmbeddingProvider {
+	return m.chain.Primary()
+}
+
+// Fallbacks returns the providers tried after the primary, in order
+func (m *Model) Fallbacks() []EmbeddingProvider {
+	providers := make([]EmbeddingProvider, 0, len(m.chain.members)-1)
+	for _, member := range m.chain.members[1:] {
+		providers = append(providers, member.provider)
+	}
+	return providers
+}
+
+// Status reports whether the model can currently be served: available when
+// the primary provider is healthy, degraded when only fallbacks are, and
+// unavailable when every breaker is open
+func (m *Model) Status() string {
+	statuses := m.chain.Status()
+	if statuses[0].Breaker.State == BreakerClosed {
+		return ModelAvailable
+	}
+	for _, status := range statuses[1:] {
+		if status.Breaker.State != BreakerOpen {
+			return ModelDegraded
+		}
+	}
+	return ModelUnavailable
+}
+
+// ModelRegistry maps public model names to their providers
+type ModelRegistry struct {
+	models       map[string]*Model
+	order        []string
+	defaultModel string
+}
+
+// NewModelRegistry builds a provider chain for every configured model
+func NewModelRegistry(cfg *config.Config) (*ModelRegistry, error) {
+	registry := &ModelRegistry{models: make(map[string]*Model)}
+
+	// Vocabularies are large, so models sharing one share the tokenizer
+	tokenizers := make(map[string]Tokenizer)
+
+	for _, modelCfg := range cfg.Models {
+		if modelCfg.Name == "" {
+			return nil, fmt.Errorf("model entry is missing a name")
+		}
+		if modelCfg.Provider == "" {
+			return nil, fmt.Errorf("model %s is missing a provider", modelCfg.Name)
+		}
+		if modelCfg.Dimension <= 0 {
+			return nil, fmt.Errorf("model %s must have a positive dimension", modelCfg.Name)
+		}
+		for inputType := range modelCfg.InputPrefixes {
+			if !IsSupportedInputType(inputType) {
+				return nil, fmt.Errorf("model %s has an input prefix for unknown input type %q", modelCfg.Name, inputType)
+			}
+		}
+		if _, exists := registry.models[modelCfg.Name]; exists {
+			return nil, fmt.Errorf("model %s is defined more than once", modelCfg.Name)
+		}
+
+		chain, err := NewProviderChain(cfg, modelCfg)
+		if err != nil {
+			return nil, fmt.Errorf("model %s: %w", modelCfg.Name, err)
+		}
+
+		tokenizerKey := modelCfg.Tokenizer + "\x00" + modelCfg.TokenizerVocab
+		tokenizer, exists := tokenizers[tokenizerKey]
+		if !exists {
+			tokenizer, err = NewTokenizer(modelCfg.Tokenizer, modelCfg.TokenizerVocab)
+			if err != nil {
+				return nil, fmt.Errorf("model %s: %w", modelCfg.Name, err)
+			}
+			tokenizers[tokenizerKey] = tokenizer
+		}
+
+		registry.models[modelCfg.Name] = &Model{
+			Name:           modelCfg.Name,
+			Dimension:      modelCfg.Dimension,
+			MaxInputLength: modelCfg.MaxInputLength,
+			MaxInputTokens: modelCfg.MaxInputTokens,
+			Normalize:      modelCfg.Normalize,
+			InputPrefixes:  modelCfg.InputPrefixes,
+			Tokenizer:      tokenizer,
+			chain:          chain,
+		}
+		registry.order = append(registry.order, modelCfg.Name)
+	}
+
+	if len(registry.order) == 0 {
+		return nil, fmt.Errorf("no embedding models configured")
+	}
+
+	registry.defaultModel = cfg.EmbeddingModel
+	if _, exists := registry.models[registry.defaultModel]; !exists {
+		registry.defaultModel = registry.order[0]
+		log.Printf("EMBEDDING_MODEL %q is not registered, defaulting to %s", cfg.EmbeddingModel, registry.defaultModel)
+	}
+
+	return registry, nil
+}
+
+// Get returns the model registered under name
+func (r *ModelRegistry) Get(name string) (*Model, error) {
+	model, exists := r.models[name]
+	if !exists {
+		return nil, fmt.Errorf("%w: %s", ErrUnknownModel, name)
+	}
+	return model, nil
+}
+
+// Default returns the model used when a request does not name one
+func (r *ModelRegistry) Default() *Model {
+	return r.models[r.defaultModel]
+}
+
+// List returns all models in configuration order
+func (r *ModelRegistry) List() []*Model {
+	models := make([]*Model, 0, len(r.order))
+	for _, name := range r.order {
+		models = append(models, r.models[name])
+	}
+	return models
+}
@@ -0,0 +1,115 @@
+package services
+
+import (
+	"batch-embedding-api/models"
+	"fmt"
+	"sort"
+	"strings"
+	"unicode/utf8"
+)
+
+// inputPage locates a page chunked on its own within the input it came from
+type inputPage struct {
+	input      int  // index of the input
+	paged      bool // false for inputs without pages, which are kept whole
+	offset     int  // character offset of the page in the input
+	lineOffset int  // lines before the page
+}
+
+// splitPages turns each input with more than one page into one input per
+// page, so that no chunk crosses a page boundary. It returns the new inputs
+// and where each of them comes from.
+func splitPages(inputs []models.InputItem) ([]models.InputItem, []inputPage) {
+	var split []models.InputItem
+	var pages []inputPage
+	for i, input := range inputs {
+		if len(input.Pages) < 2 {
+			split = append(split, input)
+			pages = append(pages, inputPage{input: i})
+			continue
+		}
+
+		// Byte offsets of the pages, and of the end of the text
+		bounds := make([]int, 0, len(input.Pages)+1)
+		chars := 0
+		for b := range input.Text {
+			for len(bounds) < len(input.Pages) && input.Pages[len(bounds)] <= chars {
+				bounds = append(bounds, b)
+			}
+			chars++
+		}
+		for len(bounds) <= len(input.Pages) {
+			bounds = append(bounds, len(input.Text))
+		}
+
+		for p, offset := range input.Pages {
+			page := input
+			page.Text = input.Text[bounds[p]:bounds[p+1]]
+			page.Pages = nil
+			split = append(split, page)
+			pages = append(pages, inputPage{
+				input:      i,
+				paged:      true,
+				offset:     offset,
+				lineOffset: strings.Count(input.Text[:bounds[p]], "\n"),
+			})
+		}
+	}
+	return split, pages
+}
+
+// joinPages collects the chunks of the pages split by splitPages under the
+// input they came from. A page short enough to be embedded whole becomes a
+// chunk of its own, and chunk offsets, line numbers and IDs are made relative
+// to the input.
+func joinPages(inputs, split []models.InputItem, pages []inputPage, chunksByPage [][]TextChunk) [][]TextChunk {
+	chunksByInput := make([][]TextChunk, len(inputs))
+	for i, page := range pages {
+		chunks := chunksByPage[i]
+		if !page.paged {
+			chunksByInput[page.input] = chunks
+			continue
+		}
+
+		if chunks == nil {
+			chunks = []TextChunk{trimChunk(TextChunk{
+				Text: split[i].Text,
+				End:  utf8.RuneCountInString(split[i].Text),
+			})}
+		}
+		docID := inputs[page.input].ID
+		for _, chunk := range chunks {
+			if chunk.Text == "" {
+				continue
+			}
+			chunk.Start += page.offset
+			chunk.End += page.offset
+			if chunk.StartLine > 0 {
+				chunk.StartLine += page.lineOffset
+				chunk.EndLine += page.lineOffset
+			}
+			chunk.ChunkID = fmt.Sprintf("%s_%d", docID, len(chunksByInput[page.input]))
+			chunksByInput[page.input] = append(chunksByInput[page.input], chunk)
+		}
+	}
+	return chunksByInput
+}
+
+// setPageRanges records the pages each chunk spans, given the character
+// offset of each page
+func setPageRanges(chunks []TextChunk, pages []int) {
+	if len(pages) == 0 {
+		return
+	}
+	for i := range chunks {
+		chunks[i].PageStart = sort.SearchInts(pages, chunks[i].Start+1)
+		chunks[i].PageEnd = sort.SearchInts(pages, chunks[i].End)
+	}
+}
+
+// inputPageRange returns the pages spanned by an input embedded whole
+func inputPageRange(input models.InputItem) (int, int) {
+	whole := []TextChunk{{End: utf8.RuneCountInString(input.Text)}}
+	setPageRanges(whole, input.Pages)
+	return whole[0].PageStart, whole[0].PageEnd
+}
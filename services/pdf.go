@@ -0,0 +1,1156 @@
+package services
+
+import (
+	"bytes"
+	"compress/flate"
+	"compress/zlib"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"regexp"
+	"strconv"
+)
+
+// Errors returned for PDFs whose text cannot be extracted
+var (
+	ErrPDFEncrypted     = errors.New("PDF is encrypted; remove the password protection and upload it again")
+	ErrPDFImageOnly     = errors.New("PDF has no text layer, only images such as scanned pages; run OCR on it first")
+	ErrPDFNoText        = errors.New("PDF contains no extractable text")
+	ErrPDFUnmappedFonts = errors.New("PDF text uses fonts without a Unicode mapping, so it cannot be extracted")
+)
+
+// ExtractionErrorCode returns the API error code for a file whose text could
+// not be extracted, using defaultCode when the PDF errors above do not apply
+func ExtractionErrorCode(err error, defaultCode string) string {
+	switch {
+	case errors.Is(err, ErrPDFEncrypted):
+		return "pdf_encrypted"
+	case errors.Is(err, ErrPDFImageOnly):
+		return "pdf_image_only"
+	case errors.Is(err, ErrPDFUnmappedFonts):
+		return "pdf_unmapped_fonts"
+	}
+	return defaultCode
+}
+
+// PDF objects are represented as nil, bool, int, float64, pdfName,
+// pdfString, pdfArray, pdfDict, pdfRef or *pdfStream
+type (
+	pdfName   string
+	pdfString []byte
+	pdfArray  []interface{}
+	pdfDict   map[pdfName]interface{}
+)
+
+// pdfRef is an indirect reference such as "12 0 R"
+type pdfRef struct {
+	num int
+	gen int
+}
+
+// pdfStream is a stream object with its still encoded data
+type pdfStream struct {
+	dict pdfDict
+	data []byte
+}
+
+// pdfKeyword is a bare word such as obj, stream or a content stream operator
+type pdfKeyword string
+
+// pdfDelimiter is one of [ ] << >> { }
+type pdfDelimiter string
+
+// pdfLexer reads tokens from PDF data
+type pdfLexer struct {
+	data []byte
+	pos  int
+}
+
+func isPDFWhitespace(c byte) bool {
+	return c == 0 || c == '\t' || c == '\n' || c == '\f' || c == '\r' || c == ' '
+}
+
+func isPDFDelimiter(c byte) bool {
+	return c == '(' || c == ')' || c == '<' || c == '>' || c == '[' || c == ']' || c == '{' || c == '}' || c == '/' || c == '%'
+}
+
+// skipSpace skips whitespace and comments
+func (l *pdfLexer) skipSpace() {
+	for l.pos < len(l.data) {
+		c := l.data[l.pos]
+		if c == '%' {
+			for l.pos < len(l.data) && l.data[l.pos] != '\n' && l.data[l.pos] != '\r' {
+				l.pos++
+			}
+			continue
+		}
+		if !isPDFWhitespace(c) {
+			return
+		}
+		l.pos++
+	}
+}
+
+// token returns the next token, or io.EOF at the end of the data
+func (l *pdfLexer) token() (interface{}, error) {
+	l.skipSpace()
+	if l.pos >= len(l.data) {
+		return nil, io.EOF
+	}
+
+	c := l.data[l.pos]
+	switch c {
+	case '[', ']', '{', '}':
+		l.pos++
+		return pdfDelimiter(c), nil
+	case '<':
+		if l.pos+1 < len(l.data) && l.data[l.pos+1] == '<' {
+			l.pos += 2
+			return pdfDelimiter("<<"), nil
+		}
+		return l.hexString()
+	case '>':
+		if l.pos+1 < len(l.data) && l.data[l.pos+1] == '>' {
+			l.pos += 2
+			return pdfDelimiter(">>"), nil
+		}
+		l.pos++
+		return nil, fmt.Errorf("unexpected '>' at offset %d", l.pos-1)
+	case '(':
+		return l.literalString(), nil
+	case ')':
+		l.pos++
+		return nil, fmt.Errorf("unexpected ')' at offset %d", l.pos-1)
+	case '/':
+		return l.name(), nil
+	}
+
+	start := l.pos
+	for l.pos < len(l.data) && !isPDFWhitespace(l.data[l.pos]) && !isPDFDelimiter(l.data[l.pos]) {
+		l.pos++
+	}
+	word := string(l.data[start:l.pos])
+
+	switch word {
+	case "true":
+		return true, nil
+	case "false":
+		return false, nil
+	}
+	if n, err := strconv.Atoi(word); err == nil {
+		return n, nil
+	}
+	if looksNumeric(word) {
+		f, err := strconv.ParseFloat(word, 64)
+		if err != nil {
+			// Malformed numbers such as "--5" or "1.2.3" count as zero
+			return 0.0, nil
+		}
+		return f, nil
+	}
+	return pdfKeyword(word), nil
+}
+
+// looksNumeric reports whether word consists of signs, digits and periods
+func looksNumeric(word string) bool {
+	for i := 0; i < len(word); i++ {
+		c := word[i]
+		if (c < '0' || c > '9') && c != '.' && c != '-' && c != '+' {
+			return false
+		}
+	}
+	return word != ""
+}
+
+// name reads a name, decoding #xx escapes
+func (l *pdfLexer) name() pdfName {
+	l.pos++ // the slash
+	var name []byte
+	for l.pos < len(l.data) && !isPDFWhitespace(l.data[l.pos]) && !isPDFDelimiter(l.data[l.pos]) {
+		c := l.data[l.pos]
+		if c == '#' && l.pos+2 < len(l.data) {
+			if decoded, err := hex.DecodeString(string(l.data[l.pos+1 : l.pos+3])); err == nil {
+				name = append(name, decoded[0])
+				l.pos += 3
+				continue
+			}
+		}
+		name = append(name, c)
+		l.pos++
+	}
+	return pdfName(name)
+}
+
+// literalString reads a (string) with balanced parentheses and escapes
+func (l *pdfLexer) literalString() pdfString {
+	l.pos++ // the opening parenthesis
+	var s []byte
+	depth := 1
+	for l.pos < len(l.data) {
+		c := l.data[l.pos]
+		l.pos++
+		switch c {
+		case '(':
+			depth++
+		case ')':
+			depth--
+			if depth == 0 {
+				return s
+			}
+		case '\\':
+			if l.pos >= len(l.data) {
+				return s
+			}
+			c = l.data[l.pos]
+			l.pos++
+			switch c {
+			case 'n':
+				c = '\n'
+			case 'r':
+				c = '\r'
+			case 't':
+				c = '\t'
+			case 'b':
+				c = '\b'
+			case 'f':
+				c = '\f'
+			case '\r':
+				// A backslash before a line break continues the string
+				if l.pos < len(l.data) && l.data[l.pos] == '\n' {
+					l.pos++
+				}
+				continue
+			case '\n':
+				continue
+			default:
+				if c >= '0' && c <= '7' {
+					value := int(c - '0')
+					for i := 0; i < 2 && l.pos < len(l.data) && l.data[l.pos] >= '0' && l.data[l.pos] <= '7'; i++ {
+						value = value*8 + int(l.data[l.pos]-'0')
+						l.pos++
+					}
+					c = byte(value)
+				}
+			}
+		case '\r':
+			// Line breaks inside strings read as a single newline
+			if l.pos < len(l.data) && l.data[l.pos] == '\n' {
+				l.pos++
+			}
+			c = '\n'
+		}
+		s = append(s, c)
+	}
+	return s
+}
+
+// hexString reads a <hex string>, padding an odd final digit with zero
+func (l *pdfLexer) hexString() (pdfString, error) {
+	l.pos++ // the opening angle bracket
+	var digits []byte
+	for l.pos < len(l.data) && l.data[l.pos] != '>' {
+		c := l.data[l.pos]
+		l.pos++
+		if isPDFWhitespace(c) {
+			continue
+		}
+		digits = append(digits, c)
+	}
+	l.pos++ // the closing angle bracket
+	if len(digits)%2 == 1 {
+		digits = append(digits, '0')
+	}
+	s, err := hex.DecodeString(string(digits))
+	if err != nil {
+		return nil, fmt.Errorf("invalid hex string at offset %d", l.pos)
+	}
+	return s, nil
+}
+
+// maxPDFNesting limits how deeply arrays and dictionaries may nest, so that
+// hostile input cannot exhaust the stack
+const maxPDFNesting = 128
+
+// maxPDFObjects is the largest object number a PDF may use
+const maxPDFObjects = 8388607
+
+// errPDFNesting is returned for objects nested deeper than maxPDFNesting
+var errPDFNesting = fmt.Errorf("PDF objects are nested more than %d levels deep", maxPDFNesting)
+
+// pdfParser reads objects from a lexer, resolving "num gen R" references
+type pdfParser struct {
+	lexer   *pdfLexer
+	pending []interface{} // tokens read ahead
+	depth   int           // arrays and dictionaries being read
+}
+
+func newPDFParser(data []byte, pos int) *pdfParser {
+	return &pdfParser{lexer: &pdfLexer{data: data, pos: pos}}
+}
+
+func (p *pdfParser) token() (interface{}, error) {
+	if len(p.pending) > 0 {
+		token := p.pending[0]
+		p.pending = p.pending[1:]
+		return token, nil
+	}
+	return p.lexer.token()
+}
+
+func (p *pdfParser) peek(n int) interface{} {
+	for len(p.pending) < n {
+		token, err := p.lexer.token()
+		if err != nil {
+			return nil
+		}
+		p.pending = append(p.pending, token)
+	}
+	return p.pending[n-1]
+}
+
+// object reads the next object. Keywords, such as content stream operators,
+// are returned as pdfKeyword.
+func (p *pdfParser) object() (interface{}, error) {
+	token, err := p.token()
+	if err != nil {
+		return nil, err
+	}
+
+	switch token := token.(type) {
+	case int:
+		// "num gen R" is a reference
+		if gen, ok := p.peek(1).(int); ok && p.peek(2) == pdfKeyword("R") {
+			p.pending = p.pending[2:]
+			return pdfRef{num: token, gen: gen}, nil
+		}
+		return token, nil
+	case pdfKeyword:
+		if token == "null" {
+			return nil, nil
+		}
+		return token, nil
+	case pdfDelimiter:
+		if token == "[" || token == "<<" {
+			if p.depth >= maxPDFNesting {
+				return nil, errPDFNesting
+			}
+			p.depth++
+			defer func() { p.depth-- }()
+		}
+		switch token {
+		case "[":
+			array := pdfArray{}
+			for {
+				if p.peek(1) == pdfDelimiter("]") {
+					p.pending = p.pending[1:]
+					return array, nil
+				}
+				item, err := p.object()
+				if err != nil {
+					return nil, err
+				}
+				array = append(array, item)
+			}
+		case "<<":
+			dict := pdfDict{}
+			for {
+				key, err := p.object()
+				if err != nil {
+					return nil, err
+				}
+				if key == pdfDelimiter(">>") {
+					return dict, nil
+				}
+				name, ok := key.(pdfName)
+				if !ok {
+					// Skip stray tokens rather than failing the whole document
+					continue
+				}
+				if p.peek(1) == pdfDelimiter(">>") {
+					return dict, nil
+				}
+				value, err := p.object()
+				if err != nil {
+					return nil, err
+				}
+				dict[name] = value
+			}
+		}
+		return token, nil
+	}
+	return token, nil
+}
+
+// pdfXrefEntry locates an object in the file or in an object stream
+type pdfXrefEntry struct {
+	offset int // byte offset of "num gen obj", or the index in an object stream
+	stream int // object number of the containing object stream, or 0
+}
+
+// pdfDocument is a parsed PDF file whose objects are loaded on demand
+type pdfDocument struct {
+	data    []byte
+	xref    map[int]pdfXrefEntry
+	trailer pdfDict
+	objects map[int]interface{}
+	loading map[int]bool // objects being loaded, to break reference cycles
+	streams map[int]*pdfObjectStream
+}
+
+// pdfObjectStream is a decoded object stream
+type pdfObjectStream struct {
+	data    []byte
+	numbers []int // number of each object
+	offsets []int // offset of each object relative to data
+}
+
+var startxrefPattern = regexp.MustCompile(`startxref\s+(\d+)`)
+
+// openPDF parses the cross-reference data of a PDF file, rebuilding it by
+// scanning for objects when it is missing or damaged
+func openPDF(data []byte) (*pdfDocument, error) {
+	header := data
+	if len(header) > 1024 {
+		header = header[:1024]
+	}
+	if !bytes.Contains(header, []byte("%PDF-")) {
+		return nil, errors.New("not a PDF file")
+	}
+
+	doc := &pdfDocument{
+		data:    data,
+		xref:    make(map[int]pdfXrefEntry),
+		objects: make(map[int]interface{}),
+		loading: make(map[int]bool),
+		streams: make(map[int]*pdfObjectStream),
+	}
+
+	tail := data
+	if len(tail) > 4096 {
+		tail = tail[len(tail)-4096:]
+	}
+	matches := startxrefPattern.FindAllSubmatch(tail, -1)
+	if len(matches) > 0 {
+		offset, _ := strconv.Atoi(string(matches[len(matches)-1][1]))
+		if err := doc.readXref(offset, make(map[int]bool)); err != nil {
+			doc.xref = make(map[int]pdfXrefEntry)
+			doc.trailer = nil
+		}
+	}
+	if doc.trailer == nil || doc.dict(doc.trailer["Root"]) == nil {
+		if err := doc.rebuildXref(); err != nil {
+			return nil, err
+		}
+	}
+
+	if doc.trailer["Encrypt"] != nil {
+		return nil, ErrPDFEncrypted
+	}
+	return doc, nil
+}
+
+// readXref reads the cross-reference section at offset and those it points
+// to through /Prev and /XRefStm. Entries already read take precedence, as
+// the first section read is the most recent.
+func (d *pdfDocument) readXref(offset int, seen map[int]bool) error {
+	if offset <= 0 || offset >= len(d.data) || seen[offset] {
+		return fmt.Errorf("invalid cross-reference offset %d", offset)
+	}
+	seen[offset] = true
+
+	parser := newPDFParser(d.data, offset)
+	first, err := parser.token()
+	if err != nil {
+		return err
+	}
+
+	var trailer pdfDict
+	if first == pdfKeyword("xref") {
+		trailer, err = d.readXrefTable(parser)
+	} else {
+		trailer, err = d.readXrefStream(offset)
+	}
+	if err != nil {
+		return err
+	}
+
+	if d.trailer == nil {
+		d.trailer = trailer
+	}
+	if stm, ok := trailer["XRefStm"].(int); ok {
+		// Hybrid files list compressed objects in a separate stream
+		if err := d.readXref(stm, seen); err != nil {
+			return err
+		}
+	}
+	if prev, ok := trailer["Prev"].(int); ok {
+		return d.readXref(prev, seen)
+	}
+	return nil
+}
+
+// readXrefTable reads a classic "xref" table followed by its trailer
+func (d *pdfDocument) readXrefTable(parser *pdfParser) (pdfDict, error) {
+	for {
+		token, err := parser.token()
+		if err != nil {
+			return nil, err
+		}
+		if token == pdfKeyword("trailer") {
+			break
+		}
+		start, ok := token.(int)
+		if !ok {
+			return nil, errors.New("invalid cross-reference table")
+		}
+		countToken, err := parser.token()
+		count, ok := countToken.(int)
+		if err != nil || !ok {
+			return nil, errors.New("invalid cross-reference table")
+		}
+
+		for i := 0; i < count; i++ {
+			offsetToken, _ := parser.token()
+			parser.token() // generation
+			kind, _ := parser.token()
+			offset, ok := offsetToken.(int)
+			if !ok {
+				return nil, errors.New("invalid cross-reference entry")
+			}
+			if _, exists := d.xref[start+i]; !exists && kind == pdfKeyword("n") {
+				d.xref[start+i] = pdfXrefEntry{offset: offset}
+			} else if !exists {
+				// Free entries hide older definitions of the object
+				d.xref[start+i] = pdfXrefEntry{offset: -1}
+			}
+		}
+	}
+
+	trailer, err := parser.object()
+	if err != nil {
+		return nil, err
+	}
+	dict, ok := trailer.(pdfDict)
+	if !ok {
+		return nil, errors.New("invalid trailer")
+	}
+	return dict, nil
+}
+
+// readXrefStream reads a cross-reference stream, whose dictionary doubles as
+// the trailer
+func (d *pdfDocument) readXrefStream(offset int) (pdfDict, error) {
+	_, object, err := d.parseIndirect(offset)
+	if err != nil {
+		return nil, err
+	}
+	stream, ok := object.(*pdfStream)
+	if !ok || stream.dict["Type"] != pdfName("XRef") {
+		return nil, errors.New("invalid cross-reference stream")
+	}
+	data, err := d.decodeStream(stream)
+	if err != nil {
+		return nil, err
+	}
+
+	// Fields are big-endian integers of up to 8 bytes
+	widths := d.intArray(stream.dict["W"])
+	if len(widths) != 3 {
+		return nil, errors.New("invalid cross-reference stream widths")
+	}
+	for _, width := range widths {
+		if width < 0 || width > 8 {
+			return nil, fmt.Errorf("invalid cross-reference stream width %d", width)
+		}
+	}
+	entrySize := widths[0] + widths[1] + widths[2]
+	if entrySize == 0 {
+		return nil, errors.New("invalid cross-reference stream widths")
+	}
+
+	index := d.intArray(stream.dict["Index"])
+	if len(index) == 0 {
+		size, _ := stream.dict["Size"].(int)
+		index = []int{0, size}
+	}
+	if len(index)%2 != 0 {
+		return nil, errors.New("invalid cross-reference stream index")
+	}
+	for i := 0; i < len(index); i += 2 {
+		if index[i] < 0 || index[i+1] < 0 || index[i] > maxPDFObjects || index[i+1] > maxPDFObjects-index[i] {
+			return nil, fmt.Errorf("invalid cross-reference stream subsection %d %d", index[i], index[i+1])
+		}
+	}
+
+	pos := 0
+	for i := 0; i+1 < len(index); i += 2 {
+		for num := index[i]; num < index[i]+index[i+1]; num++ {
+			if pos+entrySize > len(data) {
+				return stream.dict, nil
+			}
+			field := func(n int) int {
+				value := uint64(0)
+				for j := 0; j < widths[n]; j++ {
+					value = value<<8 | uint64(data[pos])
+					pos++
+				}
+				if value > math.MaxInt32 {
+					// No offset, object number or index is this large
+					return -1
+				}
+				return int(value)
+			}
+			kind := 1
+			if widths[0] > 0 {
+				kind = field(0)
+			}
+			second, third := field(1), field(2)
+
+			if _, exists := d.xref[num]; exists {
+				continue
+			}
+			switch {
+			case kind == 0:
+				d.xref[num] = pdfXrefEntry{offset: -1}
+			case kind == 1 && second >= 0:
+				d.xref[num] = pdfXrefEntry{offset: second}
+			case kind == 2 && second > 0 && third >= 0:
+				d.xref[num] = pdfXrefEntry{offset: third, stream: second}
+			case kind < 0 || second < 0 || third < 0:
+				return nil, fmt.Errorf("invalid cross-reference stream entry for object %d", num)
+			}
+		}
+	}
+	return stream.dict, nil
+}
+
+var objectPattern = regexp.MustCompile(`(\d+)\s+(\d+)\s+obj\b`)
+
+// rebuildXref locates every object by scanning the file, for documents
+// whose cross-reference data is missing or damaged
+func (d *pdfDocument) rebuildXref() error {
+	d.xref = make(map[int]pdfXrefEntry)
+	d.objects = make(map[int]interface{})
+	d.streams = make(map[int]*pdfObjectStream)
+	for _, match := range objectPattern.FindAllSubmatchIndex(d.data, -1) {
+		if match[0] > 0 && !isPDFWhitespace(d.data[match[0]-1]) && !isPDFDelimiter(d.data[match[0]-1]) {
+			continue
+		}
+		num, _ := strconv.Atoi(string(d.data[match[2]:match[3]]))
+		// Later definitions replace earlier ones, as with incremental updates
+		d.xref[num] = pdfXrefEntry{offset: match[0]}
+	}
+	if len(d.xref) == 0 {
+		return errors.New("invalid PDF: no objects found")
+	}
+
+	// Objects inside object streams
+	for num := range d.xref {
+		if stream, ok := d.object(num).(*pdfStream); ok && stream.dict["Type"] == pdfName("ObjStm") {
+			objects, err := d.objectStream(num)
+			if err != nil {
+				continue
+			}
+			for i, inner := range objects.numbers {
+				if _, exists := d.xref[inner]; !exists {
+					d.xref[inner] = pdfXrefEntry{offset: i, stream: num}
+				}
+			}
+		}
+	}
+
+	// The trailer, or failing that the catalog itself
+	d.trailer = pdfDict{}
+	if i := bytes.LastIndex(d.data, []byte("trailer")); i >= 0 {
+		if trailer, err := newPDFParser(d.data, i+len("trailer")).object(); err == nil {
+			if dict, ok := trailer.(pdfDict); ok {
+				d.trailer = dict
+			}
+		}
+	}
+	if d.trailer["Root"] == nil {
+		for num := range d.xref {
+			object := d.object(num)
+			if stream, ok := object.(*pdfStream); ok && stream.dict["Type"] == pdfName("XRef") && stream.dict["Root"] != nil {
+				d.trailer = stream.dict
+				break
+			}
+			if dict, ok := object.(pdfDict); ok && dict["Type"] == pdfName("Catalog") {
+				d.trailer["Root"] = pdfRef{num: num}
+			}
+		}
+	}
+	// Without a catalog, pages are found by scanning for page objects
+	return nil
+}
+
+// parseIndirect parses "num gen obj ... endobj" at offset, reading the data
+// of a stream object
+func (d *pdfDocument) parseIndirect(offset int) (int, interface{}, error) {
+	if offset < 0 || offset >= len(d.data) {
+		return 0, nil, fmt.Errorf("object offset %d out of range", offset)
+	}
+	parser := newPDFParser(d.data, offset)
+	numToken, _ := parser.token()
+	parser.token() // generation
+	keyword, _ := parser.token()
+	num, ok := numToken.(int)
+	if !ok || keyword != pdfKeyword("obj") {
+		return 0, nil, fmt.Errorf("no object at offset %d", offset)
+	}
+
+	object, err := parser.object()
+	if err != nil {
+		return 0, nil, err
+	}
+	dict, ok := object.(pdfDict)
+	if !ok || parser.peek(1) != pdfKeyword("stream") {
+		return num, object, nil
+	}
+
+	// The data starts after the end of line following "stream"
+	start := parser.lexer.pos
+	if start < len(d.data) && d.data[start] == '\r' {
+		start++
+	}
+	if start < len(d.data) && d.data[start] == '\n' {
+		start++
+	}
+
+	end := -1
+	if length, ok := d.resolve(dict["Length"]).(int); ok && length >= 0 && start+length <= len(d.data) {
+		rest := bytes.TrimLeft(d.data[start+length:min(start+length+32, len(d.data))], "\r\n \t")
+		if bytes.HasPrefix(rest, []byte("endstream")) {
+			end = start + length
+		}
+	}
+	if end < 0 {
+		// Wrong or missing length; search for the end marker instead, taking
+		// the rest of a truncated file
+		end = len(d.data)
+		if i := bytes.Index(d.data[start:], []byte("endstream")); i >= 0 {
+			end = start + i
+		}
+		for end > start && (d.data[end-1] == '\n' || d.data[end-1] == '\r') {
+			end--
+		}
+	}
+	return num, &pdfStream{dict: dict, data: d.data[start:end]}, nil
+}
+
+// object returns object num, or nil when it is missing or invalid
+func (d *pdfDocument) object(num int) interface{} {
+	if object, loaded := d.objects[num]; loaded {
+		return object
+	}
+	entry, exists := d.xref[num]
+	if !exists || entry.offset < 0 || d.loading[num] {
+		return nil
+	}
+	d.loading[num] = true
+	defer delete(d.loading, num)
+
+	var object interface{}
+	if entry.stream > 0 {
+		if stream, err := d.objectStream(entry.stream); err == nil && entry.offset < len(stream.offsets) {
+			object, _ = newPDFParser(stream.data, stream.offsets[entry.offset]).object()
+		}
+	} else if _, parsed, err := d.parseIndirect(entry.offset); err == nil {
+		object = parsed
+	}
+	d.objects[num] = object
+	return object
+}
+
+// objectStream decodes object stream num
+func (d *pdfDocument) objectStream(num int) (*pdfObjectStream, error) {
+	if stream, loaded := d.streams[num]; loaded {
+		return stream, nil
+	}
+	raw, ok := d.object(num).(*pdfStream)
+	if !ok {
+		return nil, fmt.Errorf("object stream %d not found", num)
+	}
+	data, err := d.decodeStream(raw)
+	if err != nil {
+		return nil, err
+	}
+
+	count, _ := raw.dict["N"].(int)
+	first, _ := raw.dict["First"].(int)
+	if count < 0 || first < 0 || first > len(data) {
+		return nil, fmt.Errorf("invalid object stream %d: /N %d, /First %d", num, count, first)
+	}
+	stream := &pdfObjectStream{data: data}
+	header := newPDFParser(data[:first], 0)
+	for i := 0; i < count; i++ {
+		number, _ := header.token()
+		offset, err := header.token()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		n, ok := number.(int)
+		o, isInt := offset.(int)
+		if !ok || !isInt || n < 0 || o < 0 || o >= len(data)-first {
+			return nil, fmt.Errorf("invalid header in object stream %d", num)
+		}
+		stream.numbers = append(stream.numbers, n)
+		stream.offsets = append(stream.offsets, first+o)
+	}
+	d.streams[num] = stream
+	return stream, nil
+}
+
+// resolve follows references until it reaches a direct object
+func (d *pdfDocument) resolve(object interface{}) interface{} {
+	for i := 0; i < 32; i++ {
+		ref, ok := object.(pdfRef)
+		if !ok {
+			return object
+		}
+		object = d.object(ref.num)
+	}
+	return nil
+}
+
+// dict resolves object to a dictionary, using a stream's dictionary
+func (d *pdfDocument) dict(object interface{}) pdfDict {
+	switch object := d.resolve(object).(type) {
+	case pdfDict:
+		return object
+	case *pdfStream:
+		return object.dict
+	}
+	return nil
+}
+
+// number resolves object to a number
+func (d *pdfDocument) number(object interface{}) (float64, bool) {
+	switch object := d.resolve(object).(type) {
+	case int:
+		return float64(object), true
+	case float64:
+		return object, true
+	}
+	return 0, false
+}
+
+// intArray resolves object to an array of integers
+func (d *pdfDocument) intArray(object interface{}) []int {
+	array, _ := d.resolve(object).(pdfArray)
+	ints := make([]int, 0, len(array))
+	for _, item := range array {
+		if n, ok := d.number(item); ok {
+			ints = append(ints, int(n))
+		}
+	}
+	return ints
+}
+
+// errPDFImageFilter marks streams compressed with an image-only filter
+var errPDFImageFilter = errors.New("image filter")
+
+// decodeStream applies the filters of a stream to its data
+func (d *pdfDocument) decodeStream(stream *pdfStream) ([]byte, error) {
+	var filters, params []interface{}
+	switch filter := d.resolve(stream.dict["Filter"]).(type) {
+	case pdfName:
+		filters = []interface{}{filter}
+		params = []interface{}{d.resolve(stream.dict["DecodeParms"])}
+	case pdfArray:
+		filters = filter
+		if array, ok := d.resolve(stream.dict["DecodeParms"]).(pdfArray); ok {
+			params = array
+		}
+	}
+
+	data := stream.data
+	for i, filter := range filters {
+		var param pdfDict
+		if i < len(params) {
+			param = d.dict(params[i])
+		}
+
+		var err error
+		switch d.resolve(filter) {
+		case pdfName("FlateDecode"), pdfName("Fl"):
+			data, err = inflate(data)
+			if err == nil {
+				data, err = d.unpredict(data, param)
+			}
+		case pdfName("LZWDecode"), pdfName("LZW"):
+			earlyChange := 1
+			if value, ok := param["EarlyChange"].(int); ok {
+				earlyChange = value
+			}
+			data = lzwDecode(data, earlyChange)
+			data, err = d.unpredict(data, param)
+		case pdfName("ASCIIHexDecode"), pdfName("AHx"):
+			data, err = asciiHexDecode(data)
+		case pdfName("ASCII85Decode"), pdfName("A85"):
+			data, err = ascii85Decode(data)
+		case pdfName("RunLengthDecode"), pdfName("RL"):
+			data = runLengthDecode(data)
+		case pdfName("DCTDecode"), pdfName("DCT"), pdfName("JPXDecode"), pdfName("CCITTFaxDecode"), pdfName("CCF"), pdfName("JBIG2Decode"):
+			return nil, errPDFImageFilter
+		case pdfName("Crypt"):
+			return nil, ErrPDFEncrypted
+		default:
+			return nil, fmt.Errorf("unsupported stream filter %v", filter)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	return data, nil
+}
+
+// maxPDFStreamSize caps the decoded size of a stream, so that a small file
+// cannot expand into gigabytes
+const maxPDFStreamSize = 256 << 20
+
+// inflate decompresses zlib data, keeping what was decoded before any
+// corruption, and accepts raw deflate data without the zlib header
+func inflate(data []byte) ([]byte, error) {
+	var reader io.ReadCloser
+	reader, err := zlib.NewReader(bytes.NewReader(data))
+	if err != nil {
+		reader = flate.NewReader(bytes.NewReader(data))
+	}
+	defer reader.Close()
+
+	out, err := io.ReadAll(io.LimitReader(reader, maxPDFStreamSize))
+	if err != nil && len(out) == 0 {
+		return nil, fmt.Errorf("corrupt compressed stream: %w", err)
+	}
+	return out, nil
+}
+
+// maxPDFPredictorColumns bounds the row width of predicted streams
+const maxPDFPredictorColumns = 1 << 20
+
+// unpredict reverses the PNG predictors used with Flate and LZW streams
+func (d *pdfDocument) unpredict(data []byte, params pdfDict) ([]byte, error) {
+	predictor, _ := params["Predictor"].(int)
+	if predictor < 10 {
+		if predictor == 2 {
+			return nil, errors.New("unsupported TIFF predictor")
+		}
+		return data, nil
+	}
+
+	colors, bits, columns := 1, 8, 1
+	if value, ok := params["Colors"].(int); ok {
+		colors = value
+	}
+	if value, ok := params["BitsPerComponent"].(int); ok {
+		bits = value
+	}
+	if value, ok := params["Columns"].(int); ok {
+		columns = value
+	}
+	if colors < 1 || colors > 32 {
+		return nil, fmt.Errorf("invalid predictor colors %d", colors)
+	}
+	if bits != 1 && bits != 2 && bits != 4 && bits != 8 && bits != 16 {
+		return nil, fmt.Errorf("invalid predictor bits per component %d", bits)
+	}
+	if columns < 1 || columns > maxPDFPredictorColumns {
+		return nil, fmt.Errorf("invalid predictor columns %d", columns)
+	}
+	bytesPerPixel := max((colors*bits+7)/8, 1)
+	rowSize := (colors*bits*columns + 7) / 8
+	if rowSize >= len(data) {
+		// Not even one row with its predictor byte
+		return nil, nil
+	}
+
+	out := make([]byte, 0, len(data))
+	previous := make([]byte, rowSize)
+	for pos := 0; pos+1+rowSize <= len(data); pos += 1 + rowSize {
+		kind := data[pos]
+		row := append([]byte(nil), data[pos+1:pos+1+rowSize]...)
+		for i := range row {
+			var left, upLeft byte
+			if i >= bytesPerPixel {
+				left = row[i-bytesPerPixel]
+				upLeft = previous[i-bytesPerPixel]
+			}
+			up := previous[i]
+			switch kind {
+			case 1:
+				row[i] += left
+			case 2:
+				row[i] += up
+			case 3:
+				row[i] += byte((int(left) + int(up)) / 2)
+			case 4:
+				row[i] += paeth(left, up, upLeft)
+			}
+		}
+		out = append(out, row...)
+		previous = row
+	}
+	return out, nil
+}
+
+func paeth(a, b, c byte) byte {
+	p := int(a) + int(b) - int(c)
+	pa, pb, pc := abs(p-int(a)), abs(p-int(b)), abs(p-int(c))
+	if pa <= pb && pa <= pc {
+		return a
+	}
+	if pb <= pc {
+		return b
+	}
+	return c
+}
+
+func abs(n int) int {
+	if n < 0 {
+		return -n
+	}
+	return n
+}
+
+// lzwDecode decompresses LZW data with the PDF variant's code width changes
+func lzwDecode(data []byte, earlyChange int) []byte {
+	var out []byte
+	table := make([][]byte, 258, 4096)
+	for i := 0; i < 256; i++ {
+		table[i] = []byte{byte(i)}
+	}
+	width := 9
+	var previous []byte
+	var buffer, bufferBits int
+
+	for pos := 0; ; {
+		for bufferBits < width && pos < len(data) {
+			buffer = buffer<<8 | int(data[pos])
+			bufferBits += 8
+			pos++
+		}
+		if bufferBits < width {
+			return out
+		}
+		code := (buffer >> (bufferBits - width)) & (1<<width - 1)
+		bufferBits -= width
+
+		switch {
+		case code == 256:
+			table = table[:258]
+			width = 9
+			previous = nil
+			continue
+		case code == 257:
+			return out
+		}
+
+		var entry []byte
+		if code < len(table) {
+			entry = table[code]
+		} else if previous != nil {
+			entry = append(append([]byte(nil), previous...), previous[0])
+		} else {
+			return out
+		}
+		out = append(out, entry...)
+		if len(out) > maxPDFStreamSize {
+			return out
+		}
+
+		if previous != nil && len(table) < 4096 {
+			table = append(table, append(append([]byte(nil), previous...), entry[0]))
+		}
+		previous = entry
+
+		if len(table)+earlyChange >= 1<<width && width < 12 {
+			width++
+		}
+	}
+}
+
+// asciiHexDecode decodes ASCIIHexDecode data, which ends at '>'
+func asciiHexDecode(data []byte) ([]byte, error) {
+	var digits []byte
+	for _, c := range data {
+		if c == '>' {
+			break
+		}
+		if !isPDFWhitespace(c) {
+			digits = append(digits, c)
+		}
+	}
+	if len(digits)%2 == 1 {
+		digits = append(digits, '0')
+	}
+	return hex.DecodeString(string(digits))
+}
+
+// ascii85Decode decodes ASCII85Decode data, which ends at "~>"
+func ascii85Decode(data []byte) ([]byte, error) {
+	var out []byte
+	var group [5]byte
+	n := 0
+	for _, c := range data {
+		switch {
+		case c == '~':
+			goto done
+		case isPDFWhitespace(c):
+			continue
+		case c == 'z' && n == 0:
+			out = append(out, 0, 0, 0, 0)
+			continue
+		case c < '!' || c > 'u':
+			return nil, errors.New("invalid ASCII85 data")
+		}
+		group[n] = c - '!'
+		n++
+		if n == 5 {
+			out = appendASCII85Group(out, group, 4)
+			n = 0
+		}
+	}
+done:
+	if n > 1 {
+		for i := n; i < 5; i++ {
+			group[i] = 'u' - '!'
+		}
+		out = appendASCII85Group(out, group, n-1)
+	}
+	return out, nil
+}
+
+func appendASCII85Group(out []byte, group [5]byte, count int) []byte {
+	var value uint32
+	for _, digit := range group {
+		value = value*85 + uint32(digit)
+	}
+	decoded := []byte{byte(value >> 24), byte(value >> 16), byte(value >> 8), byte(value)}
+	return append(out, decoded[:count]...)
+}
+
+// runLengthDecode decodes RunLengthDecode data
+func runLengthDecode(data []byte) []byte {
+	var out []byte
+	for pos := 0; pos < len(data); {
+		length := int(data[pos])
+		pos++
+		switch {
+		case length == 128:
+			return out
+		case length < 128:
+			end := min(pos+length+1, len(data))
+			out = append(out, data[pos:end]...)
+			pos = end
+		case pos < len(data):
+			for i := 0; i < 257-length; i++ {
+				out = append(out, data[pos])
+			}
+			pos++
+		}
+	}
+	return out
+}
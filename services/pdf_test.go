@@ -0,0 +1,290 @@
+package services
+
+import (
+	"bytes"
+	"compress/zlib"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"testing"
+)
+
+// buildPDF assembles a PDF with a classic cross-reference table from the
+// bodies of objects 1, 2, ..., the first of which is the catalog
+func buildPDF(objects ...string) []byte {
+	var buf bytes.Buffer
+	buf.WriteString("%PDF-1.7\n")
+	offsets := make([]int, len(objects))
+	for i, object := range objects {
+		offsets[i] = buf.Len()
+		fmt.Fprintf(&buf, "%d 0 obj\n%s\nendobj\n", i+1, object)
+	}
+	xref := buf.Len()
+	fmt.Fprintf(&buf, "xref\n0 %d\n0000000000 65535 f \n", len(objects)+1)
+	for _, offset := range offsets {
+		fmt.Fprintf(&buf, "%010d 00000 n \n", offset)
+	}
+	fmt.Fprintf(&buf, "trailer\n<< /Size %d /Root 1 0 R >>\nstartxref\n%d\n%%%%EOF\n", len(objects)+1, xref)
+	return buf.Bytes()
+}
+
+// pdfStreamObject returns the body of a stream object holding data
+func pdfStreamObject(dict string, data []byte) string {
+	return fmt.Sprintf("<< %s /Length %d >>\nstream\n%s\nendstream", dict, len(data), data)
+}
+
+// zlibCompress compresses data as FlateDecode expects
+func zlibCompress(data []byte) []byte {
+	var buf bytes.Buffer
+	writer := zlib.NewWriter(&buf)
+	writer.Write(data)
+	writer.Close()
+	return buf.Bytes()
+}
+
+func TestOpenPDFXref(t *testing.T) {
+	tests := []struct {
+		name string
+		file string
+		want map[int]pdfXrefEntry
+	}{
+		{
+			name: "classic table",
+			file: "testdata/two_column.pdf",
+			want: map[int]pdfXrefEntry{0: {offset: -1}, 1: {offset: 15}, 4: {offset: 247}, 5: {offset: 555}},
+		},
+		{
+			name: "stream with object stream",
+			file: "testdata/xref_stream.pdf",
+			want: map[int]pdfXrefEntry{0: {offset: -1}, 1: {offset: 0, stream: 10}, 4: {offset: 137}, 7: {offset: 4, stream: 10}},
+		},
+		{
+			name: "damaged offsets are rebuilt",
+			file: "testdata/damaged_xref.pdf",
+			want: map[int]pdfXrefEntry{1: {offset: 15}, 5: {offset: 630}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			data, err := os.ReadFile(tt.file)
+			if err != nil {
+				t.Fatal(err)
+			}
+			doc, err := openPDF(data)
+			if err != nil {
+				t.Fatalf("openPDF: %v", err)
+			}
+			for num, want := range tt.want {
+				if got := doc.xref[num]; got != want {
+					t.Errorf("xref[%d] = %+v, want %+v", num, got, want)
+				}
+			}
+			if catalog := doc.dict(doc.trailer["Root"]); catalog["Type"] != pdfName("Catalog") {
+				t.Errorf("Root = %v, want the catalog", catalog)
+			}
+		})
+	}
+}
+
+func TestObjectStream(t *testing.T) {
+	data, err := os.ReadFile("testdata/xref_stream.pdf")
+	if err != nil {
+		t.Fatal(err)
+	}
+	doc, err := openPDF(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	stream, err := doc.objectStream(10)
+	if err != nil {
+		t.Fatalf("objectStream: %v", err)
+	}
+	if want := []int{1, 2, 5, 6, 7}; fmt.Sprint(stream.numbers) != fmt.Sprint(want) {
+		t.Errorf("numbers = %v, want %v", stream.numbers, want)
+	}
+	if pages := doc.dict(doc.object(2)); pages["Type"] != pdfName("Pages") {
+		t.Errorf("object 2 = %v, want the page tree", pages)
+	}
+}
+
+func TestDecodeStream(t *testing.T) {
+	// Three rows of three bytes with the None, Sub and Up PNG predictors
+	predicted := []byte{0, 1, 2, 3, 1, 1, 1, 1, 2, 5, 5, 5}
+
+	tests := []struct {
+		name string
+		dict pdfDict
+		data []byte
+		want string
+	}{
+		{
+			name: "flate",
+			dict: pdfDict{"Filter": pdfName("FlateDecode")},
+			data: zlibCompress([]byte("BT (Hello) Tj ET")),
+			want: "BT (Hello) Tj ET",
+		},
+		{
+			name: "flate with PNG predictor",
+			dict: pdfDict{
+				"Filter":      pdfName("FlateDecode"),
+				"DecodeParms": pdfDict{"Predictor": 12, "Columns": 3},
+			},
+			data: zlibCompress(predicted),
+			want: "\x01\x02\x03\x01\x02\x03\x06\x07\x08",
+		},
+		{
+			name: "LZW",
+			dict: pdfDict{"Filter": pdfName("LZWDecode")},
+			data: []byte{0x80, 0x0B, 0x60, 0x50, 0x22, 0x0C, 0x0C, 0x85, 0x01},
+			want: "-----A---B",
+		},
+		{
+			name: "ASCIIHex",
+			dict: pdfDict{"Filter": pdfName("ASCIIHexDecode")},
+			data: []byte("48 65 6c 6C 6f7>"),
+			want: "Hellop",
+		},
+		{
+			name: "ASCII85",
+			dict: pdfDict{"Filter": pdfName("ASCII85Decode")},
+			data: []byte("87cURD_*#-6q/=~>"),
+			want: "Hello, PDF!",
+		},
+		{
+			name: "RunLength",
+			dict: pdfDict{"Filter": pdfName("RunLengthDecode")},
+			data: []byte{2, 'a', 'b', 'c', 254, 'x', 128},
+			want: "abcxxx",
+		},
+		{
+			name: "filter chain",
+			dict: pdfDict{"Filter": pdfArray{pdfName("ASCIIHexDecode"), pdfName("FlateDecode")}},
+			data: []byte(fmt.Sprintf("%x>", zlibCompress([]byte("chained")))),
+			want: "chained",
+		},
+	}
+
+	doc := &pdfDocument{}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := doc.decodeStream(&pdfStream{dict: tt.dict, data: tt.data})
+			if err != nil {
+				t.Fatalf("decodeStream: %v", err)
+			}
+			if string(got) != tt.want {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestUnpredictRejectsInvalidParameters(t *testing.T) {
+	tests := []struct {
+		name   string
+		params pdfDict
+	}{
+		{"zero colors", pdfDict{"Predictor": 12, "Colors": 0}},
+		{"too many colors", pdfDict{"Predictor": 12, "Colors": 1 << 40}},
+		{"odd bits", pdfDict{"Predictor": 12, "BitsPerComponent": 3}},
+		{"negative columns", pdfDict{"Predictor": 12, "Columns": -1}},
+		{"huge columns", pdfDict{"Predictor": 12, "Columns": 1 << 62}},
+		{"TIFF predictor", pdfDict{"Predictor": 2}},
+	}
+
+	doc := &pdfDocument{}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := doc.unpredict([]byte{0, 1, 2, 3}, tt.params); err == nil {
+				t.Error("expected an error")
+			}
+		})
+	}
+}
+
+func TestReadXrefStreamRejectsInvalidEntries(t *testing.T) {
+	tests := []struct {
+		name string
+		dict string
+	}{
+		{"negative width", "/W [1 -2 1] /Size 2"},
+		{"width over 8 bytes", "/W [1 9 1] /Size 2"},
+		{"all widths zero", "/W [0 0 0] /Size 2"},
+		{"negative index", "/W [1 2 1] /Index [-5 2]"},
+		{"odd index", "/W [1 2 1] /Index [0 2 7]"},
+		{"index past the object limit", "/W [1 2 1] /Index [8388600 100]"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			data := buildPDF(
+				"<< /Type /Catalog >>",
+				pdfStreamObject("/Type /XRef "+tt.dict, []byte{1, 0, 9, 0, 1, 0, 15, 0}),
+			)
+			doc, err := openPDF(data)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, err := doc.readXrefStream(doc.xref[2].offset); err == nil {
+				t.Error("expected an error")
+			}
+		})
+	}
+}
+
+func TestObjectStreamRejectsInvalidHeaders(t *testing.T) {
+	tests := []struct {
+		name   string
+		dict   string
+		header string
+	}{
+		{"negative first", "/N 1 /First -4", "3 0 "},
+		{"first past the data", "/N 1 /First 999", "3 0 "},
+		{"negative offset", "/N 1 /First 5", "3 -2 "},
+		{"offset past the data", "/N 1 /First 5", "3 99 "},
+		{"negative object number", "/N 1 /First 5", "-3 0 "},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			data := buildPDF(
+				"<< /Type /Catalog >>",
+				pdfStreamObject("/Type /ObjStm "+tt.dict, []byte(tt.header+"<< /A 1 >>")),
+			)
+			doc, err := openPDF(data)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, err := doc.objectStream(2); err == nil {
+				t.Error("expected an error")
+			}
+		})
+	}
+}
+
+func TestParserNestingLimit(t *testing.T) {
+	tests := []struct {
+		name    string
+		input   string
+		wantErr bool
+	}{
+		{"arrays at the limit", strings.Repeat("[", maxPDFNesting) + strings.Repeat("]", maxPDFNesting), false},
+		{"arrays past the limit", strings.Repeat("[", maxPDFNesting+1) + strings.Repeat("]", maxPDFNesting+1), true},
+		{"dictionaries past the limit", strings.Repeat("<< /A ", maxPDFNesting+1) + strings.Repeat(">> ", maxPDFNesting+1), true},
+		{"unterminated arrays", strings.Repeat("[", 4<<20), true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := newPDFParser([]byte(tt.input), 0).object()
+			if tt.wantErr && !errors.Is(err, errPDFNesting) {
+				t.Errorf("err = %v, want %v", err, errPDFNesting)
+			}
+			if !tt.wantErr && err != nil {
+				t.Errorf("unexpected error: %v", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,1319 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode/utf16"
+)
+
+// maxFormDepth limits how deeply Form XObjects may nest
+const maxFormDepth = 10
+
+// pdfPage is a page with the resources it inherits from the page tree
+type pdfPage struct {
+	dict      pdfDict
+	resources pdfDict
+}
+
+// pages returns the pages of the document in order, falling back to every
+// page object in the file when the page tree is broken
+func (d *pdfDocument) pages() []pdfPage {
+	var pages []pdfPage
+	visited := make(map[int]bool)
+	var walk func(node interface{}, resources pdfDict, depth int)
+	walk = func(node interface{}, resources pdfDict, depth int) {
+		if ref, ok := node.(pdfRef); ok {
+			if visited[ref.num] {
+				return
+			}
+			visited[ref.num] = true
+		}
+		dict := d.dict(node)
+		if dict == nil || depth > 64 {
+			return
+		}
+		if own := d.dict(dict["Resources"]); own != nil {
+			resources = own
+		}
+		if kids, ok := d.resolve(dict["Kids"]).(pdfArray); ok {
+			for _, kid := range kids {
+				walk(kid, resources, depth+1)
+			}
+			return
+		}
+		if dict["Type"] == pdfName("Page") || dict["Contents"] != nil {
+			pages = append(pages, pdfPage{dict: dict, resources: resources})
+		}
+	}
+	walk(d.dict(d.trailer["Root"])["Pages"], nil, 0)
+	if len(pages) > 0 {
+		return pages
+	}
+
+	nums := make([]int, 0, len(d.xref))
+	for num := range d.xref {
+		nums = append(nums, num)
+	}
+	sort.Ints(nums)
+	for _, num := range nums {
+		if dict, ok := d.object(num).(pdfDict); ok && dict["Type"] == pdfName("Page") {
+			pages = append(pages, pdfPage{dict: dict, resources: d.inheritedResources(dict)})
+		}
+	}
+	return pages
+}
+
+// inheritedResources returns the resources of a page or of the nearest node
+// above it in the page tree
+func (d *pdfDocument) inheritedResources(node pdfDict) pdfDict {
+	for depth := 0; node != nil && depth < 64; depth++ {
+		if resources := d.dict(node["Resources"]); resources != nil {
+			return resources
+		}
+		node = d.dict(node["Parent"])
+	}
+	return nil
+}
+
+// contents returns the decoded content streams of a page
+func (d *pdfDocument) contents(page pdfPage) []byte {
+	var streams []interface{}
+	switch contents := d.resolve(page.dict["Contents"]).(type) {
+	case *pdfStream:
+		streams = []interface{}{contents}
+	case pdfArray:
+		streams = contents
+	}
+
+	var data []byte
+	for _, object := range streams {
+		stream, ok := d.resolve(object).(*pdfStream)
+		if !ok {
+			continue
+		}
+		decoded, err := d.decodeStream(stream)
+		if err != nil {
+			continue
+		}
+		// Streams are concatenated, and may split an operator between them
+		data = append(append(data, decoded...), '\n')
+	}
+	return data
+}
+
+// pdfMatrix is an affine transformation [a b c d e f]
+type pdfMatrix [6]float64
+
+var identityMatrix = pdfMatrix{1, 0, 0, 1, 0, 0}
+
+// multiply returns m followed by n
+func (m pdfMatrix) multiply(n pdfMatrix) pdfMatrix {
+	return pdfMatrix{
+		m[0]*n[0] + m[1]*n[2],
+		m[0]*n[1] + m[1]*n[3],
+		m[2]*n[0] + m[3]*n[2],
+		m[2]*n[1] + m[3]*n[3],
+		m[4]*n[0] + m[5]*n[2] + n[4],
+		m[4]*n[1] + m[5]*n[3] + n[5],
+	}
+}
+
+func translation(x, y float64) pdfMatrix {
+	return pdfMatrix{1, 0, 0, 1, x, y}
+}
+
+// pdfTextState holds the text parameters of the graphics state
+type pdfTextState struct {
+	font      *pdfFont
+	size      float64
+	charSpace float64
+	wordSpace float64
+	scale     float64 // horizontal scaling, 1 for 100%
+	leading   float64
+	rise      float64
+}
+
+type pdfGraphicsState struct {
+	ctm  pdfMatrix
+	text pdfTextState
+}
+
+// pdfGlyph is a character drawn on a page, in coordinates rotated so that
+// its baseline is horizontal
+type pdfGlyph struct {
+	text     string
+	along    float64 // position along the baseline
+	baseline float64 // position of the baseline, increasing up the page
+	width    float64
+	size     float64
+	angle    int // direction of the baseline in degrees
+}
+
+// pdfTextExtractor interprets content streams, collecting the glyphs drawn
+type pdfTextExtractor struct {
+	doc      *pdfDocument
+	fonts    map[int]*pdfFont
+	glyphs   []pdfGlyph
+	images   int // images drawn, to tell scans from empty documents
+	unmapped int // glyphs whose font has no Unicode mapping
+	depth    int
+	err      error // content that cannot be interpreted safely
+}
+
+// extractPDFPages returns the text of each page of a PDF in reading order
+func extractPDFPages(ctx context.Context, content []byte) (pages []string, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			pages, err = nil, fmt.Errorf("invalid PDF: %v", r)
+		}
+	}()
+
+	doc, err := openPDF(content)
+	if err != nil {
+		return nil, err
+	}
+	pdfPages := doc.pages()
+	if len(pdfPages) == 0 {
+		return nil, errors.New("PDF has no pages")
+	}
+
+	extractor := &pdfTextExtractor{doc: doc, fonts: make(map[int]*pdfFont)}
+	pages = make([]string, len(pdfPages))
+	found := false
+	for i, page := range pdfPages {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		extractor.glyphs = extractor.glyphs[:0]
+		state := pdfGraphicsState{ctm: identityMatrix, text: pdfTextState{scale: 1}}
+		extractor.run(doc.contents(page), page.resources, state)
+		if extractor.err != nil {
+			return nil, fmt.Errorf("invalid PDF: page %d: %w", i+1, extractor.err)
+		}
+		pages[i] = layoutPDFPage(extractor.glyphs)
+		found = found || strings.TrimSpace(pages[i]) != ""
+	}
+
+	if !found {
+		switch {
+		case extractor.unmapped > 0:
+			return nil, ErrPDFUnmappedFonts
+		case extractor.images > 0:
+			return nil, ErrPDFImageOnly
+		}
+		return nil, ErrPDFNoText
+	}
+	return pages, nil
+}
+
+// run interprets a content stream
+func (x *pdfTextExtractor) run(content []byte, resources pdfDict, state pdfGraphicsState) {
+	var stack []pdfGraphicsState
+	tm, tlm := identityMatrix, identityMatrix
+	var operands []interface{}
+	parser := newPDFParser(content, 0)
+
+	number := func(i int) float64 {
+		if i < len(operands) {
+			if n, ok := x.doc.number(operands[i]); ok {
+				return n
+			}
+		}
+		return 0
+	}
+	nextLine := func(tx, ty float64) {
+		tlm = translation(tx, ty).multiply(tlm)
+		tm = tlm
+	}
+
+	for x.err == nil {
+		object, err := parser.object()
+		if errors.Is(err, io.EOF) {
+			return
+		}
+		if errors.Is(err, errPDFNesting) {
+			x.err = err
+			return
+		}
+		if err != nil {
+			operands = operands[:0]
+			continue
+		}
+		operator, ok := object.(pdfKeyword)
+		if !ok {
+			operands = append(operands, object)
+			continue
+		}
+
+		switch operator {
+		case "q":
+			stack = append(stack, state)
+		case "Q":
+			if len(stack) > 0 {
+				state = stack[len(stack)-1]
+				stack = stack[:len(stack)-1]
+			}
+		case "cm":
+			if len(operands) == 6 {
+				m := pdfMatrix{number(0), number(1), number(2), number(3), number(4), number(5)}
+				state.ctm = m.multiply(state.ctm)
+			}
+		case "BT":
+			tm, tlm = identityMatrix, identityMatrix
+		case "Tc":
+			state.text.charSpace = number(0)
+		case "Tw":
+			state.text.wordSpace = number(0)
+		case "Tz":
+			state.text.scale = number(0) / 100
+		case "TL":
+			state.text.leading = number(0)
+		case "Ts":
+			state.text.rise = number(0)
+		case "Tf":
+			if len(operands) == 2 {
+				name, _ := operands[0].(pdfName)
+				state.text.font = x.font(resources, name)
+				state.text.size = number(1)
+			}
+		case "Td":
+			nextLine(number(0), number(1))
+		case "TD":
+			state.text.leading = -number(1)
+			nextLine(number(0), number(1))
+		case "Tm":
+			if len(operands) == 6 {
+				tlm = pdfMatrix{number(0), number(1), number(2), number(3), number(4), number(5)}
+				tm = tlm
+			}
+		case "T*":
+			nextLine(0, -state.text.leading)
+		case "Tj":
+			if len(operands) > 0 {
+				tm = x.show(operands[0], tm, state)
+			}
+		case "'":
+			nextLine(0, -state.text.leading)
+			if len(operands) > 0 {
+				tm = x.show(operands[0], tm, state)
+			}
+		case "\"":
+			if len(operands) == 3 {
+				state.text.wordSpace = number(0)
+				state.text.charSpace = number(1)
+				nextLine(0, -state.text.leading)
+				tm = x.show(operands[2], tm, state)
+			}
+		case "TJ":
+			if len(operands) == 0 {
+				break
+			}
+			items, _ := operands[0].(pdfArray)
+			for _, item := range items {
+				if adjustment, ok := x.doc.number(item); ok {
+					// Adjustments are in thousandths of text space units
+					tm = translation(-adjustment/1000*state.text.size*state.text.scale, 0).multiply(tm)
+					continue
+				}
+				tm = x.show(item, tm, state)
+			}
+		case "Do":
+			if len(operands) > 0 {
+				name, _ := operands[0].(pdfName)
+				x.drawXObject(resources, name, state)
+			}
+		case "BI":
+			x.skipInlineImage(parser)
+		}
+		operands = operands[:0]
+	}
+}
+
+// show draws a string, returning the text matrix after it
+func (x *pdfTextExtractor) show(object interface{}, tm pdfMatrix, state pdfGraphicsState) pdfMatrix {
+	s, ok := object.(pdfString)
+	if !ok {
+		return tm
+	}
+	font := state.text.font
+	if font == nil {
+		font = defaultPDFFont
+	}
+
+	text := state.text
+	for _, code := range font.codes(s) {
+		m := tm.multiply(state.ctm)
+		glyphText, mapped := font.text(code)
+		width := font.width(code) * text.size * text.scale
+
+		advance := font.width(code)*text.size + text.charSpace
+		if len(code) == 1 && code[0] == ' ' {
+			advance += text.wordSpace
+		}
+		advance *= text.scale
+
+		if !mapped {
+			x.unmapped++
+		} else if glyphText != "" {
+			x.addGlyph(glyphText, m, width, text)
+		}
+		tm = translation(advance, 0).multiply(tm)
+	}
+	return tm
+}
+
+// addGlyph records a glyph drawn with the text rendering matrix m
+func (x *pdfTextExtractor) addGlyph(text string, m pdfMatrix, width float64, state pdfTextState) {
+	// The glyph origin, raised by the text rise
+	originX := state.rise*m[2] + m[4]
+	originY := state.rise*m[3] + m[5]
+
+	scaleX := math.Hypot(m[0], m[1])
+	dx, dy := 1.0, 0.0
+	if scaleX > 0 {
+		dx, dy = m[0]/scaleX, m[1]/scaleX
+	}
+	size := math.Abs(state.size) * math.Hypot(m[2], m[3])
+	if size == 0 {
+		size = 1
+	}
+
+	x.glyphs = append(x.glyphs, pdfGlyph{
+		text:     text,
+		along:    originX*dx + originY*dy,
+		baseline: originY*dx - originX*dy,
+		width:    math.Abs(width) * scaleX,
+		size:     size,
+		angle:    int(math.Round(math.Atan2(dy, dx) * 180 / math.Pi)),
+	})
+}
+
+// drawXObject draws the named XObject, interpreting Form XObjects and
+// counting images
+func (x *pdfTextExtractor) drawXObject(resources pdfDict, name pdfName, state pdfGraphicsState) {
+	stream, ok := x.doc.resolve(x.doc.dict(resources["XObject"])[name]).(*pdfStream)
+	if !ok {
+		return
+	}
+
+	switch stream.dict["Subtype"] {
+	case pdfName("Image"):
+		x.images++
+	case pdfName("Form"):
+		if x.depth >= maxFormDepth {
+			return
+		}
+		content, err := x.doc.decodeStream(stream)
+		if err != nil {
+			return
+		}
+		formResources := x.doc.dict(stream.dict["Resources"])
+		if formResources == nil {
+			formResources = resources
+		}
+		if matrix, ok := x.doc.resolve(stream.dict["Matrix"]).(pdfArray); ok && len(matrix) == 6 {
+			var m pdfMatrix
+			for i := range m {
+				m[i], _ = x.doc.number(matrix[i])
+			}
+			state.ctm = m.multiply(state.ctm)
+		}
+
+		x.depth++
+		x.run(content, formResources, state)
+		x.depth--
+	}
+}
+
+// skipInlineImage skips the dictionary and data of an inline image, which
+// ends at an EI operator surrounded by whitespace
+func (x *pdfTextExtractor) skipInlineImage(parser *pdfParser) {
+	x.images++
+	for {
+		object, err := parser.object()
+		if err != nil {
+			return
+		}
+		if object == pdfKeyword("ID") {
+			break
+		}
+	}
+
+	data := parser.lexer.data
+	parser.pending = nil
+	for pos := parser.lexer.pos + 1; pos+2 <= len(data); pos++ {
+		if data[pos] == 'E' && data[pos+1] == 'I' && isPDFWhitespace(data[pos-1]) &&
+			(pos+2 == len(data) || isPDFWhitespace(data[pos+2]) || isPDFDelimiter(data[pos+2])) {
+			parser.lexer.pos = pos + 2
+			return
+		}
+	}
+	parser.lexer.pos = len(data)
+}
+
+// font returns the named font of a resource dictionary
+func (x *pdfTextExtractor) font(resources pdfDict, name pdfName) *pdfFont {
+	object := x.doc.dict(resources["Font"])[name]
+	ref, isRef := object.(pdfRef)
+	if isRef {
+		if font, loaded := x.fonts[ref.num]; loaded {
+			return font
+		}
+	}
+
+	font := defaultPDFFont
+	if dict := x.doc.dict(object); dict != nil {
+		font = newPDFFont(x.doc, dict)
+	}
+	if isRef {
+		x.fonts[ref.num] = font
+	}
+	return font
+}
+
+// pdfFont maps character codes to text and widths
+type pdfFont struct {
+	composite    bool            // a Type0 font with multi-byte codes
+	encoding     *pdfCMap        // code to CID mapping of a composite font
+	unicodeCodes bool            // codes of a composite font are UTF-16
+	toUnicode    *pdfCMap        // the font's ToUnicode CMap
+	simple       *[256]string    // text of each code of a simple font
+	widths       map[int]float64 // glyph widths in glyph space, by code or CID
+	defaultWidth float64
+	widthScale   float64 // glyph space to text space, 1/1000 except for Type3
+}
+
+// defaultPDFFont is used when a content stream draws text without a valid
+// font
+var defaultPDFFont = &pdfFont{simple: &standardEncoding, defaultWidth: 500, widthScale: 0.001}
+
+// newPDFFont loads a font dictionary
+func newPDFFont(doc *pdfDocument, dict pdfDict) *pdfFont {
+	font := &pdfFont{widths: make(map[int]float64), widthScale: 0.001}
+	if stream, ok := doc.resolve(dict["ToUnicode"]).(*pdfStream); ok {
+		if data, err := doc.decodeStream(stream); err == nil {
+			font.toUnicode = parsePDFCMap(data)
+		}
+	}
+
+	if dict["Subtype"] == pdfName("Type0") {
+		font.composite = true
+		switch encoding := doc.resolve(dict["Encoding"]).(type) {
+		case pdfName:
+			font.unicodeCodes = strings.Contains(string(encoding), "UCS2") || strings.Contains(string(encoding), "UTF16")
+		case *pdfStream:
+			if data, err := doc.decodeStream(encoding); err == nil {
+				font.encoding = parsePDFCMap(data)
+			}
+		}
+
+		font.defaultWidth = 1000
+		descendants, _ := doc.resolve(dict["DescendantFonts"]).(pdfArray)
+		if len(descendants) == 0 {
+			return font
+		}
+		descendant := doc.dict(descendants[0])
+		if width, ok := doc.number(descendant["DW"]); ok {
+			font.defaultWidth = width
+		}
+		font.loadCIDWidths(doc, descendant["W"])
+		return font
+	}
+
+	// Simple fonts
+	first, _ := doc.number(dict["FirstChar"])
+	widths, hasWidths := doc.resolve(dict["Widths"]).(pdfArray)
+	for i, width := range widths {
+		if w, ok := doc.number(width); ok {
+			font.widths[int(first)+i] = w
+		}
+	}
+	font.defaultWidth, _ = doc.number(doc.dict(dict["FontDescriptor"])["MissingWidth"])
+	if !hasWidths {
+		// One of the standard fonts, whose metrics are not embedded
+		font.defaultWidth = 500
+		if base, _ := dict["BaseFont"].(pdfName); strings.Contains(string(base), "Courier") {
+			font.defaultWidth = 600
+		}
+	}
+	if dict["Subtype"] == pdfName("Type3") {
+		if matrix, ok := doc.resolve(dict["FontMatrix"]).(pdfArray); ok && len(matrix) > 0 {
+			font.widthScale, _ = doc.number(matrix[0])
+		}
+	}
+
+	table := standardEncoding
+	var differences pdfArray
+	switch encoding := doc.resolve(dict["Encoding"]).(type) {
+	case pdfName:
+		table = baseEncoding(encoding)
+	case pdfDict:
+		if base, ok := encoding["BaseEncoding"].(pdfName); ok {
+			table = baseEncoding(base)
+		}
+		differences, _ = doc.resolve(encoding["Differences"]).(pdfArray)
+	}
+	code := 0
+	for _, item := range differences {
+		switch item := doc.resolve(item).(type) {
+		case int:
+			code = item
+		case pdfName:
+			if code >= 0 && code < 256 {
+				table[code] = glyphText(string(item))
+			}
+			code++
+		}
+	}
+	font.simple = &table
+	return font
+}
+
+// loadCIDWidths reads the W array of a CIDFont, which lists either
+// "c [w1 w2 ...]" or "cfirst clast w"
+func (f *pdfFont) loadCIDWidths(doc *pdfDocument, object interface{}) {
+	array, _ := doc.resolve(object).(pdfArray)
+	for i := 0; i < len(array); {
+		first, ok := doc.number(array[i])
+		if !ok || i+1 >= len(array) {
+			return
+		}
+		if widths, ok := doc.resolve(array[i+1]).(pdfArray); ok {
+			for j, width := range widths {
+				if w, ok := doc.number(width); ok {
+					f.widths[int(first)+j] = w
+				}
+			}
+			i += 2
+			continue
+		}
+		if i+2 >= len(array) {
+			return
+		}
+		last, _ := doc.number(array[i+1])
+		width, _ := doc.number(array[i+2])
+		for cid := int(first); cid <= int(last) && cid-int(first) < 65536; cid++ {
+			f.widths[cid] = width
+		}
+		i += 3
+	}
+}
+
+// codes splits a string into character codes
+func (f *pdfFont) codes(s []byte) [][]byte {
+	var codes [][]byte
+	for pos := 0; pos < len(s); {
+		size := 1
+		switch {
+		case f.encoding != nil:
+			size = f.encoding.codeLength(s[pos:], 2)
+		case f.composite && f.toUnicode != nil:
+			size = f.toUnicode.codeLength(s[pos:], 2)
+		case f.composite:
+			size = 2
+		}
+		size = min(size, len(s)-pos)
+		codes = append(codes, s[pos:pos+size])
+		pos += size
+	}
+	return codes
+}
+
+// text returns the text of a character code, and false when the font
+// gives no way to map it to Unicode
+func (f *pdfFont) text(code []byte) (string, bool) {
+	if f.toUnicode != nil {
+		if text, ok := f.toUnicode.unicode(code); ok {
+			return text, true
+		}
+	}
+	if f.simple != nil {
+		text := f.simple[code[0]]
+		return text, text != ""
+	}
+	if f.unicodeCodes {
+		return decodeUTF16(code), true
+	}
+	return "", false
+}
+
+// width returns the advance of a character code in text space units
+func (f *pdfFont) width(code []byte) float64 {
+	key := int(codeValue(code))
+	if f.encoding != nil {
+		if cid, ok := f.encoding.cid(code); ok {
+			key = cid
+		}
+	}
+	width, ok := f.widths[key]
+	if !ok {
+		width = f.defaultWidth
+	}
+	return width * f.widthScale
+}
+
+// pdfCMap is a parsed CMap, mapping codes to Unicode text or to CIDs
+type pdfCMap struct {
+	codespace []pdfCodespace
+	chars     map[string]string // code bytes to text
+	ranges    []pdfCMapRange
+	cidChars  map[string]int
+	cidRanges []pdfCMapRange
+}
+
+// pdfCodespace is a range of valid codes of one length
+type pdfCodespace struct {
+	low, high []byte
+}
+
+// pdfCMapRange maps consecutive codes to consecutive text or CIDs
+type pdfCMapRange struct {
+	low, high []byte
+	text      []byte   // UTF-16 text of the first code
+	texts     []string // text of each code, when listed individually
+	cid       int
+}
+
+// parsePDFCMap reads the codespace, bfchar, bfrange, cidchar and cidrange
+// sections of a CMap
+func parsePDFCMap(data []byte) *pdfCMap {
+	cmap := &pdfCMap{chars: make(map[string]string), cidChars: make(map[string]int)}
+	parser := newPDFParser(data, 0)
+
+	// section reads the objects up to the keyword ending a section
+	section := func(end pdfKeyword) []interface{} {
+		var objects []interface{}
+		for {
+			object, err := parser.object()
+			if err != nil || object == end {
+				return objects
+			}
+			objects = append(objects, object)
+		}
+	}
+
+	for {
+		object, err := parser.object()
+		if errors.Is(err, io.EOF) || errors.Is(err, errPDFNesting) {
+			return cmap
+		}
+		switch object {
+		case pdfKeyword("begincodespacerange"):
+			objects := section("endcodespacerange")
+			for i := 0; i+1 < len(objects); i += 2 {
+				low, _ := objects[i].(pdfString)
+				high, _ := objects[i+1].(pdfString)
+				if len(low) > 0 && len(low) == len(high) {
+					cmap.codespace = append(cmap.codespace, pdfCodespace{low: low, high: high})
+				}
+			}
+		case pdfKeyword("beginbfchar"):
+			objects := section("endbfchar")
+			for i := 0; i+1 < len(objects); i += 2 {
+				code, _ := objects[i].(pdfString)
+				switch dst := objects[i+1].(type) {
+				case pdfString:
+					cmap.chars[string(code)] = decodeUTF16(dst)
+				case pdfName:
+					cmap.chars[string(code)] = glyphText(string(dst))
+				}
+			}
+		case pdfKeyword("beginbfrange"):
+			objects := section("endbfrange")
+			for i := 0; i+2 < len(objects); i += 3 {
+				low, _ := objects[i].(pdfString)
+				high, _ := objects[i+1].(pdfString)
+				if len(low) == 0 || len(low) != len(high) {
+					continue
+				}
+				entry := pdfCMapRange{low: low, high: high}
+				switch dst := objects[i+2].(type) {
+				case pdfString:
+					entry.text = dst
+				case pdfArray:
+					for _, item := range dst {
+						text, _ := item.(pdfString)
+						entry.texts = append(entry.texts, decodeUTF16(text))
+					}
+				}
+				cmap.ranges = append(cmap.ranges, entry)
+			}
+		case pdfKeyword("begincidchar"):
+			objects := section("endcidchar")
+			for i := 0; i+1 < len(objects); i += 2 {
+				code, _ := objects[i].(pdfString)
+				if cid, ok := objects[i+1].(int); ok {
+					cmap.cidChars[string(code)] = cid
+				}
+			}
+		case pdfKeyword("begincidrange"):
+			objects := section("endcidrange")
+			for i := 0; i+2 < len(objects); i += 3 {
+				low, _ := objects[i].(pdfString)
+				high, _ := objects[i+1].(pdfString)
+				cid, ok := objects[i+2].(int)
+				if ok && len(low) > 0 && len(low) == len(high) {
+					cmap.cidRanges = append(cmap.cidRanges, pdfCMapRange{low: low, high: high, cid: cid})
+				}
+			}
+		}
+	}
+}
+
+// codeLength returns the length of the code at the start of s, or fallback
+// when no codespace range matches
+func (c *pdfCMap) codeLength(s []byte, fallback int) int {
+	for size := 1; size <= 4 && size <= len(s); size++ {
+		for _, space := range c.codespace {
+			if len(space.low) != size {
+				continue
+			}
+			inside := true
+			for i := 0; i < size; i++ {
+				if s[i] < space.low[i] || s[i] > space.high[i] {
+					inside = false
+					break
+				}
+			}
+			if inside {
+				return size
+			}
+		}
+	}
+	return fallback
+}
+
+// contains reports whether code lies in the range
+func (r *pdfCMapRange) contains(code []byte) bool {
+	return len(code) == len(r.low) && string(code) >= string(r.low) && string(code) <= string(r.high)
+}
+
+// unicode returns the text of a code
+func (c *pdfCMap) unicode(code []byte) (string, bool) {
+	if text, ok := c.chars[string(code)]; ok {
+		return text, true
+	}
+	for i := range c.ranges {
+		r := &c.ranges[i]
+		if !r.contains(code) {
+			continue
+		}
+		offset := int(codeValue(code) - codeValue(r.low))
+		if r.texts != nil {
+			if offset < len(r.texts) {
+				return r.texts[offset], true
+			}
+			return "", false
+		}
+		// The offset is added to the last UTF-16 code unit of the first text
+		units := utf16Units(r.text)
+		if len(units) == 0 {
+			return "", false
+		}
+		units[len(units)-1] += uint16(offset)
+		return string(utf16.Decode(units)), true
+	}
+	return "", false
+}
+
+// cid returns the CID of a code
+func (c *pdfCMap) cid(code []byte) (int, bool) {
+	if cid, ok := c.cidChars[string(code)]; ok {
+		return cid, true
+	}
+	for i := range c.cidRanges {
+		if r := &c.cidRanges[i]; r.contains(code) {
+			return r.cid + int(codeValue(code)-codeValue(r.low)), true
+		}
+	}
+	return 0, false
+}
+
+// codeValue returns a code as a big-endian number
+func codeValue(code []byte) uint32 {
+	var value uint32
+	for _, b := range code {
+		value = value<<8 | uint32(b)
+	}
+	return value
+}
+
+func utf16Units(b []byte) []uint16 {
+	if len(b) == 1 {
+		return []uint16{uint16(b[0])}
+	}
+	units := make([]uint16, len(b)/2)
+	for i := range units {
+		units[i] = uint16(b[2*i])<<8 | uint16(b[2*i+1])
+	}
+	return units
+}
+
+// decodeUTF16 decodes big-endian UTF-16 text
+func decodeUTF16(b []byte) string {
+	return string(utf16.Decode(utf16Units(b)))
+}
+
+// glyphText returns the text of a glyph name, such as "eacute", "uni00E9",
+// "u1F600" or the ligature "f_f_i"
+func glyphText(name string) string {
+	if text, ok := glyphNames[name]; ok {
+		return text
+	}
+	if i := strings.IndexByte(name, '.'); i > 0 {
+		// Variants such as "a.sc" or "one.oldstyle"
+		return glyphText(name[:i])
+	}
+	if strings.Contains(name, "_") {
+		var text strings.Builder
+		for _, part := range strings.Split(name, "_") {
+			text.WriteString(glyphText(part))
+		}
+		return text.String()
+	}
+	if strings.HasPrefix(name, "uni") && len(name) >= 7 && (len(name)-3)%4 == 0 {
+		var units []uint16
+		for i := 3; i < len(name); i += 4 {
+			unit, err := strconv.ParseUint(name[i:i+4], 16, 16)
+			if err != nil {
+				return ""
+			}
+			units = append(units, uint16(unit))
+		}
+		return string(utf16.Decode(units))
+	}
+	if strings.HasPrefix(name, "u") && len(name) >= 5 && len(name) <= 7 {
+		if r, err := strconv.ParseUint(name[1:], 16, 32); err == nil {
+			return string(rune(r))
+		}
+	}
+	return ""
+}
+
+// Glyph names of printable ASCII from space, and of Latin-1 from exclamdown;
+// "-" marks codes without a name of their own
+const (
+	asciiGlyphNames = `space exclam quotedbl numbersign dollar percent ampersand quotesingle
+		parenleft parenright asterisk plus comma hyphen period slash
+		zero one two three four five six seven eight nine colon semicolon less equal greater question
+		at A B C D E F G H I J K L M N O P Q R S T U V W X Y Z
+		bracketleft backslash bracketright asciicircum underscore
+		grave a b c d e f g h i j k l m n o p q r s t u v w x y z braceleft bar braceright asciitilde`
+	latinGlyphNames = `exclamdown cent sterling currency yen brokenbar section dieresis
+		copyright ordfeminine guillemotleft logicalnot - registered macron
+		degree plusminus twosuperior threesuperior acute mu paragraph periodcentered
+		cedilla onesuperior ordmasculine guillemotright onequarter onehalf threequarters questiondown
+		Agrave Aacute Acircumflex Atilde Adieresis Aring AE Ccedilla
+		Egrave Eacute Ecircumflex Edieresis Igrave Iacute Icircumflex Idieresis
+		Eth Ntilde Ograve Oacute Ocircumflex Otilde Odieresis multiply
+		Oslash Ugrave Uacute Ucircumflex Udieresis Yacute Thorn germandbls
+		agrave aacute acircumflex atilde adieresis aring ae ccedilla
+		egrave eacute ecircumflex edieresis igrave iacute icircumflex idieresis
+		eth ntilde ograve oacute ocircumflex otilde odieresis divide
+		oslash ugrave uacute ucircumflex udieresis yacute thorn ydieresis`
+)
+
+// glyphNames maps glyph names to their text
+var glyphNames = func() map[string]string {
+	names := map[string]string{
+		"Euro": "€", "quotesinglbase": "‚", "florin": "ƒ", "quotedblbase": "„",
+		"ellipsis": "…", "dagger": "†", "daggerdbl": "‡", "circumflex": "ˆ",
+		"perthousand": "‰", "Scaron": "Š", "guilsinglleft": "‹", "OE": "Œ",
+		"Zcaron": "Ž", "quoteleft": "‘", "quoteright": "’", "quotedblleft": "“",
+		"quotedblright": "”", "bullet": "•", "endash": "–", "emdash": "—",
+		"tilde": "˜", "trademark": "™", "scaron": "š", "guilsinglright": "›",
+		"oe": "œ", "zcaron": "ž", "Ydieresis": "Ÿ", "dotlessi": "ı",
+		"fraction": "⁄", "minus": "−", "Lslash": "Ł", "lslash": "ł",
+		"breve": "˘", "dotaccent": "˙", "ring": "˚", "hungarumlaut": "˝",
+		"ogonek": "˛", "caron": "ˇ", "nbspace": " ", "sfthyphen": "-",
+		"middot": "·", "ff": "ff", "fi": "fi", "fl": "fl", "ffi": "ffi", "ffl": "ffl",
+		"notequal": "≠", "infinity": "∞", "lessequal": "≤", "greaterequal": "≥",
+		"partialdiff": "∂", "summation": "∑", "product": "∏", "pi": "π",
+		"integral": "∫", "Omega": "Ω", "radical": "√", "approxequal": "≈",
+		"Delta": "∆", "lozenge": "◊", "apple": "",
+	}
+	for i, name := range strings.Fields(asciiGlyphNames) {
+		names[name] = string(rune(' ' + i))
+	}
+	for i, name := range strings.Fields(latinGlyphNames) {
+		if name != "-" {
+			names[name] = string(rune(0xA1 + i))
+		}
+	}
+	return names
+}()
+
+// Simple font encodings
+var (
+	standardEncoding = newEncoding(map[int]string{
+		0x27: "’", 0x60: "‘",
+		0xA1: "¡", 0xA2: "¢", 0xA3: "£", 0xA4: "⁄", 0xA5: "¥", 0xA6: "ƒ", 0xA7: "§", 0xA8: "¤",
+		0xA9: "'", 0xAA: "“", 0xAB: "«", 0xAC: "‹", 0xAD: "›", 0xAE: "fi", 0xAF: "fl",
+		0xB1: "–", 0xB2: "†", 0xB3: "‡", 0xB4: "·", 0xB6: "¶", 0xB7: "•", 0xB8: "‚",
+		0xB9: "„", 0xBA: "”", 0xBB: "»", 0xBC: "…", 0xBD: "‰", 0xBF: "¿",
+		0xC1: "`", 0xC2: "´", 0xC3: "ˆ", 0xC4: "˜", 0xC5: "¯", 0xC6: "˘", 0xC7: "˙",
+		0xC8: "¨", 0xCA: "˚", 0xCB: "¸", 0xCD: "˝", 0xCE: "˛", 0xCF: "ˇ", 0xD0: "—",
+		0xE1: "Æ", 0xE3: "ª", 0xE8: "Ł", 0xE9: "Ø", 0xEA: "Œ", 0xEB: "º",
+		0xF1: "æ", 0xF5: "ı", 0xF8: "ł", 0xF9: "ø", 0xFA: "œ", 0xFB: "ß",
+	})
+	winAnsiEncoding  = newEncoding(upperHalf(0x80, "€\x00‚ƒ„…†‡ˆ‰Š‹Œ\x00Ž\x00\x00‘’“”•–—˜™š›œ\x00žŸ", latin1Upper()))
+	macRomanEncoding = newEncoding(upperHalf(0x80, ""+
+		"ÄÅÇÉÑÖÜáàâäãåçéè"+
+		"êëíìîïñóòôöõúùûü"+
+		"†°¢£§•¶ß®©™´¨≠ÆØ"+
+		"∞±≤≥¥µ∂∑∏π∫ªºΩæø"+
+		"¿¡¬√ƒ≈∆«»… ÀÃÕŒœ"+
+		"–—“”‘’÷◊ÿŸ⁄¤‹›ﬁﬂ"+
+		"‡·‚„‰ÂÊÁËÈÍÎÏÌÓÔ"+
+		"ÒÚÛÙıˆ˜¯˘˙˚¸˝˛ˇ", nil))
+)
+
+// newEncoding returns printable ASCII with the given codes replaced
+func newEncoding(codes map[int]string) [256]string {
+	var table [256]string
+	for c := ' '; c <= '~'; c++ {
+		table[c] = string(c)
+	}
+	for code, text := range codes {
+		table[code] = text
+	}
+	return table
+}
+
+// upperHalf maps the characters of chars to consecutive codes from first,
+// skipping NUL, adding them to codes
+func upperHalf(first int, chars string, codes map[int]string) map[int]string {
+	if codes == nil {
+		codes = make(map[int]string)
+	}
+	for i, r := range []rune(chars) {
+		if r != 0 {
+			codes[first+i] = string(r)
+		}
+	}
+	return codes
+}
+
+// latin1Upper maps codes 0xA0 to 0xFF to the Latin-1 characters
+func latin1Upper() map[int]string {
+	codes := make(map[int]string)
+	for code := 0xA0; code <= 0xFF; code++ {
+		codes[code] = string(rune(code))
+	}
+	codes[0xA0] = " "
+	codes[0xAD] = "-"
+	return codes
+}
+
+// baseEncoding returns the table of a named encoding
+func baseEncoding(name pdfName) [256]string {
+	switch name {
+	case "WinAnsiEncoding":
+		return winAnsiEncoding
+	case "MacRomanEncoding":
+		return macRomanEncoding
+	}
+	return standardEncoding
+}
+
+// Layout thresholds, relative to the font size
+const (
+	pdfRowTolerance = 0.5  // baselines closer than this share a row
+	pdfWordGap      = 0.15 // a wider gap between glyphs separates words
+	pdfFragmentGap  = 1.5  // a wider gap may separate columns
+)
+
+// pdfFragment is a run of words within a row
+type pdfFragment struct {
+	text       string
+	start, end float64
+}
+
+// pdfRow is a line of text across the page
+type pdfRow struct {
+	baseline  float64
+	size      float64
+	fragments []pdfFragment
+}
+
+// pdfTextLine is a line of text in reading order
+type pdfTextLine struct {
+	text     string
+	baseline float64
+	size     float64
+}
+
+var ligatureReplacer = strings.NewReplacer("ﬀ", "ff", "ﬁ", "fi", "ﬂ", "fl", "ﬃ", "ffi", "ﬄ", "ffl", " ", " ")
+
+// layoutPDFPage arranges the glyphs of a page in reading order. Glyphs are
+// grouped into rows by baseline, with spaces where the gap between glyphs
+// suggests one; rows split into two columns when a gutter runs down the page,
+// and a blank line marks wider spacing between lines as a paragraph break.
+func layoutPDFPage(glyphs []pdfGlyph) string {
+	// Text in each direction is laid out separately, most common first
+	byAngle := make(map[int][]pdfGlyph)
+	var angles []int
+	for _, glyph := range glyphs {
+		if _, seen := byAngle[glyph.angle]; !seen {
+			angles = append(angles, glyph.angle)
+		}
+		byAngle[glyph.angle] = append(byAngle[glyph.angle], glyph)
+	}
+	sort.SliceStable(angles, func(i, j int) bool {
+		return len(byAngle[angles[i]]) > len(byAngle[angles[j]])
+	})
+
+	var blocks []string
+	for _, angle := range angles {
+		if text := layoutRows(pdfRows(byAngle[angle])); text != "" {
+			blocks = append(blocks, text)
+		}
+	}
+	return ligatureReplacer.Replace(strings.Join(blocks, "\n\n"))
+}
+
+// pdfRows groups glyphs into rows from the top of the page down and splits
+// each row into fragments at wide gaps
+func pdfRows(glyphs []pdfGlyph) []pdfRow {
+	sort.SliceStable(glyphs, func(i, j int) bool { return glyphs[i].baseline > glyphs[j].baseline })
+
+	var groups [][]pdfGlyph
+	var rowBaseline, rowSize float64
+	for _, glyph := range glyphs {
+		n := len(groups)
+		if n > 0 && rowBaseline-glyph.baseline <= pdfRowTolerance*math.Max(rowSize, glyph.size) {
+			groups[n-1] = append(groups[n-1], glyph)
+			rowSize = math.Max(rowSize, glyph.size)
+			continue
+		}
+		groups = append(groups, []pdfGlyph{glyph})
+		rowBaseline, rowSize = glyph.baseline, glyph.size
+	}
+
+	rows := make([]pdfRow, 0, len(groups))
+	for _, group := range groups {
+		sort.SliceStable(group, func(i, j int) bool { return group[i].along < group[j].along })
+		row := pdfRow{baseline: group[0].baseline}
+		var text strings.Builder
+		var fragment pdfFragment
+		var previous *pdfGlyph
+		for i := range group {
+			glyph := &group[i]
+			row.size = math.Max(row.size, glyph.size)
+			if previous != nil {
+				gap := glyph.along - (previous.along + previous.width)
+				// Overprinted duplicates simulate bold text
+				if glyph.text == previous.text && math.Abs(glyph.along-previous.along) < 0.2*glyph.size {
+					continue
+				}
+				if gap > pdfFragmentGap*math.Max(glyph.size, previous.size) {
+					fragment.text = strings.TrimSpace(text.String())
+					row.fragments = appendFragment(row.fragments, fragment)
+					text.Reset()
+					previous = nil
+				} else if gap > pdfWordGap*math.Max(glyph.size, previous.size) {
+					text.WriteByte(' ')
+				}
+			}
+			if previous == nil {
+				fragment = pdfFragment{start: glyph.along}
+			}
+			text.WriteString(glyph.text)
+			fragment.end = math.Max(fragment.end, glyph.along+glyph.width)
+			previous = glyph
+		}
+		fragment.text = strings.TrimSpace(text.String())
+		row.fragments = appendFragment(row.fragments, fragment)
+		if len(row.fragments) > 0 {
+			rows = append(rows, row)
+		}
+	}
+	return rows
+}
+
+// appendFragment appends a fragment unless it is blank, collapsing runs of
+// whitespace within it
+func appendFragment(fragments []pdfFragment, fragment pdfFragment) []pdfFragment {
+	if fragment.text == "" {
+		return fragments
+	}
+	fragment.text = strings.Join(strings.Fields(fragment.text), " ")
+	return append(fragments, fragment)
+}
+
+// pdfGutter finds the x position of the gutter between two columns: one
+// that few rows cross, with at least three rows of text on either side that
+// mostly fill their column. It returns false for single-column text and for
+// tables, whose cells are narrower than columns of text.
+func pdfGutter(rows []pdfRow) (float64, bool) {
+	left, right := math.Inf(1), math.Inf(-1)
+	var candidates []float64
+	for _, row := range rows {
+		for _, fragment := range row.fragments {
+			left = math.Min(left, fragment.start)
+			right = math.Max(right, fragment.end)
+			candidates = append(candidates, fragment.end+0.01)
+		}
+	}
+	if len(rows) < 6 || len(candidates) > 4000 {
+		return 0, false
+	}
+	width := right - left
+
+	best, bestCrossings := 0.0, -1
+	for _, x := range candidates {
+		if x < left+0.25*width || x > right-0.25*width {
+			continue
+		}
+
+		// The extent of each row's text on either side of x
+		crossings, leftRows, rightRows := 0, 0, 0
+		leftWidth, rightWidth := 0.0, 0.0
+		for _, row := range rows {
+			leftStart, leftEnd := math.Inf(1), math.Inf(-1)
+			rightStart, rightEnd := math.Inf(1), math.Inf(-1)
+			crossed := false
+			for _, fragment := range row.fragments {
+				switch {
+				case fragment.end <= x:
+					leftStart, leftEnd = math.Min(leftStart, fragment.start), math.Max(leftEnd, fragment.end)
+				case fragment.start >= x:
+					rightStart, rightEnd = math.Min(rightStart, fragment.start), math.Max(rightEnd, fragment.end)
+				default:
+					crossed = true
+				}
+			}
+			switch {
+			case crossed:
+				crossings++
+				continue
+			case leftEnd > leftStart:
+				leftRows++
+				leftWidth += leftEnd - leftStart
+			}
+			if rightEnd > rightStart {
+				rightRows++
+				rightWidth += rightEnd - rightStart
+			}
+		}
+
+		if leftRows < 3 || rightRows < 3 || crossings > len(rows)/10 {
+			continue
+		}
+		leftAverage, rightAverage := leftWidth/float64(leftRows), rightWidth/float64(rightRows)
+		if leftAverage < math.Max(0.5*(x-left), 0.3*width) || rightAverage < math.Max(0.5*(right-x), 0.3*width) {
+			continue
+		}
+		if bestCrossings < 0 || crossings < bestCrossings {
+			best, bestCrossings = x, crossings
+		}
+	}
+	return best, bestCrossings >= 0
+}
+
+// layoutRows orders rows into lines, reading the left column before the
+// right one between rows that span both
+func layoutRows(rows []pdfRow) string {
+	if len(rows) == 0 {
+		return ""
+	}
+	gutter, columns := pdfGutter(rows)
+
+	var blocks [][]pdfTextLine
+	var leftLines, rightLines []pdfTextLine
+	flush := func() {
+		for _, lines := range [][]pdfTextLine{leftLines, rightLines} {
+			if len(lines) > 0 {
+				blocks = append(blocks, lines)
+			}
+		}
+		leftLines, rightLines = nil, nil
+	}
+
+	for _, row := range rows {
+		line := func(fragments []pdfFragment) pdfTextLine {
+			texts := make([]string, len(fragments))
+			for i, fragment := range fragments {
+				texts[i] = fragment.text
+			}
+			return pdfTextLine{text: strings.Join(texts, " "), baseline: row.baseline, size: row.size}
+		}
+
+		var leftFragments, rightFragments []pdfFragment
+		spans := !columns
+		for _, fragment := range row.fragments {
+			switch {
+			case fragment.end <= gutter:
+				leftFragments = append(leftFragments, fragment)
+			case fragment.start >= gutter:
+				rightFragments = append(rightFragments, fragment)
+			default:
+				spans = true
+			}
+		}
+		if spans {
+			flush()
+			blocks = append(blocks, []pdfTextLine{line(row.fragments)})
+			continue
+		}
+		if len(leftFragments) > 0 {
+			leftLines = append(leftLines, line(leftFragments))
+		}
+		if len(rightFragments) > 0 {
+			rightLines = append(rightLines, line(rightFragments))
+		}
+	}
+	flush()
+
+	spacing := lineSpacing(rows)
+	var text strings.Builder
+	var previous *pdfTextLine
+	for _, block := range blocks {
+		for i := range block {
+			line := &block[i]
+			if previous != nil {
+				text.WriteString(lineBreak(previous, line, spacing))
+			}
+			text.WriteString(line.text)
+			previous = line
+		}
+	}
+	return text.String()
+}
+
+// lineSpacing returns the median distance between consecutive rows
+func lineSpacing(rows []pdfRow) float64 {
+	var gaps []float64
+	for i := 1; i < len(rows); i++ {
+		if gap := rows[i-1].baseline - rows[i].baseline; gap < 3*rows[i].size {
+			gaps = append(gaps, gap)
+		}
+	}
+	if len(gaps) == 0 {
+		return 0
+	}
+	sort.Float64s(gaps)
+	return gaps[len(gaps)/2]
+}
+
+// lineBreak returns the separator between two lines: a paragraph break when
+// they are further apart than usual, differ in font size or the second moves
+// up to another column, and a line break otherwise
+func lineBreak(previous, line *pdfTextLine, spacing float64) string {
+	gap := previous.baseline - line.baseline
+	size := math.Max(previous.size, line.size)
+	switch {
+	case gap <= 0,
+		spacing > 0 && gap > 1.3*spacing+0.1*size,
+		spacing == 0 && gap > 1.6*size,
+		math.Abs(previous.size-line.size) > 0.2*size:
+		return "\n\n"
+	}
+	return "\n"
+}
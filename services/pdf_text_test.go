@@ -0,0 +1,166 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestExtractPDFPages(t *testing.T) {
+	tests := []struct {
+		name    string
+		file    string
+		pages   int
+		want    []string // text expected in this order
+		wantErr error
+	}{
+		{
+			name:  "two columns are read one at a time",
+			file:  "testdata/two_column.pdf",
+			pages: 1,
+			want:  []string{"A Study of Two Columns", "Col1 line0 text(ok) café", "Col1 line7", "Col2 line0", "Col2 line7"},
+		},
+		{
+			name:  "ToUnicode CMap through an object stream",
+			file:  "testdata/xref_stream.pdf",
+			pages: 1,
+			want:  []string{"Hello", "e\u0301 fi😀"}, // the CMap maps to a combining accent
+		},
+		{
+			name:  "encoding differences with damaged offsets",
+			file:  "testdata/damaged_xref.pdf",
+			pages: 1,
+			want:  []string{"café final", "Line two", "“quoted”", "after image"},
+		},
+		{
+			name:  "blank page",
+			file:  "testdata/pages.pdf",
+			pages: 3,
+			want:  []string{"Alpha sentence number 5 ends here.", "Gamma sentence number 0"},
+		},
+		{name: "encrypted", file: "testdata/encrypted.pdf", wantErr: ErrPDFEncrypted},
+		{name: "scanned", file: "testdata/image_only.pdf", wantErr: ErrPDFImageOnly},
+		{name: "fonts without Unicode", file: "testdata/unmapped_font.pdf", wantErr: ErrPDFUnmappedFonts},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			data, err := os.ReadFile(tt.file)
+			if err != nil {
+				t.Fatal(err)
+			}
+			pages, err := extractPDFPages(context.Background(), data)
+			if tt.wantErr != nil {
+				if !errors.Is(err, tt.wantErr) {
+					t.Fatalf("err = %v, want %v", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("extractPDFPages: %v", err)
+			}
+			if len(pages) != tt.pages {
+				t.Fatalf("got %d pages, want %d", len(pages), tt.pages)
+			}
+
+			text := strings.Join(pages, "\n\n")
+			pos := 0
+			for _, want := range tt.want {
+				i := strings.Index(text[pos:], want)
+				if i < 0 {
+					t.Fatalf("%q not found in order in %q", want, text)
+				}
+				pos += i + len(want)
+			}
+		})
+	}
+}
+
+func TestExtractPDFPagesRejectsMalformedInput(t *testing.T) {
+	page := func(content string) []byte {
+		return buildPDF(
+			"<< /Type /Catalog /Pages 2 0 R >>",
+			"<< /Type /Pages /Kids [3 0 R] /Count 1 >>",
+			"<< /Type /Page /Parent 2 0 R /Resources << /Font << /F1 5 0 R >> >> /Contents 4 0 R >>",
+			pdfStreamObject("", []byte(content)),
+			"<< /Type /Font /Subtype /Type1 /BaseFont /Helvetica /Encoding /WinAnsiEncoding >>",
+		)
+	}
+	valid := page("BT /F1 12 Tf 72 720 Td (Hello) Tj ET")
+
+	tests := []struct {
+		name    string
+		data    []byte
+		wantErr error
+	}{
+		{name: "not a PDF", data: []byte("hello world")},
+		{name: "header only", data: []byte("%PDF-1.7\n")},
+		{name: "truncated before the pages", data: valid[:60]},
+		{name: "deeply nested content", data: page("BT /F1 12 Tf " + strings.Repeat("[", 4<<20)), wantErr: errPDFNesting},
+		{name: "deeply nested operands", data: page("BT /F1 12 Tf " + strings.Repeat("<< /A ", 1000) + "(x) Tj ET")},
+		{
+			name: "deeply nested page tree",
+			data: buildPDF(
+				"<< /Type /Catalog /Pages 2 0 R >>",
+				"<< /Type /Pages /Kids "+strings.Repeat("[", 1<<20)+" >>",
+			),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pages, err := extractPDFPages(context.Background(), tt.data)
+			if err == nil {
+				t.Fatalf("expected an error, got pages %q", pages)
+			}
+			if tt.wantErr != nil && !errors.Is(err, tt.wantErr) {
+				t.Errorf("err = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+
+	if pages, err := extractPDFPages(context.Background(), valid); err != nil || pages[0] != "Hello" {
+		t.Errorf("valid page = %q, %v, want \"Hello\"", pages, err)
+	}
+}
+
+func TestParsePDFCMap(t *testing.T) {
+	cmap := parsePDFCMap([]byte(`/CIDInit /ProcSet findresource begin
+begincmap
+1 begincodespacerange <0000> <FFFF> endcodespacerange
+2 beginbfchar
+<0003> <0020>
+<0011> <D83DDE00>
+endbfchar
+2 beginbfrange
+<0024> <0026> <0041>
+<0050> <0051> [<0066 0069> <00E9>]
+endbfrange
+endcmap`))
+
+	tests := []struct {
+		code string
+		want string
+	}{
+		{"\x00\x03", " "},
+		{"\x00\x11", "😀"},
+		{"\x00\x24", "A"},
+		{"\x00\x26", "C"},
+		{"\x00\x50", "fi"},
+		{"\x00\x51", "é"},
+	}
+	for _, tt := range tests {
+		got, ok := cmap.unicode([]byte(tt.code))
+		if !ok || got != tt.want {
+			t.Errorf("unicode(%x) = %q, %v, want %q", tt.code, got, ok, tt.want)
+		}
+	}
+	if _, ok := cmap.unicode([]byte("\x00\x27")); ok {
+		t.Error("code outside every range was mapped")
+	}
+	if got := cmap.codeLength([]byte("\x00\x24\x00\x25"), 1); got != 2 {
+		t.Errorf("codeLength = %d, want 2", got)
+	}
+}
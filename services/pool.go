@@ -0,0 +1,192 @@
+package services
+
+import (
+	"batch-embedding-api/config"
+	"context"
+	"sync"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+// BatchSizer is implemented by providers that accept a limited number of
+// inputs per upstream request
+type BatchSizer interface {
+	BatchSize() int
+}
+
+// ProviderPool bounds the number of in-flight upstream requests to a
+// provider and optionally paces them to a requests-per-second limit.
+// Providers that learn about upstream throttling can pause the pool.
+type ProviderPool struct {
+	slots       chan struct{}
+	limiter     *rate.Limiter
+	mutex       sync.Mutex
+	pausedUntil time.Time
+}
+
+var (
+	providerPools = make(map[string]*ProviderPool)
+	poolsMutex    sync.Mutex
+)
+
+// poolForProvider returns the pool shared by every instance of the named
+// provider, creating it on first use
+func poolForProvider(name string, cfg *config.Config) *ProviderPool {
+	poolsMutex.Lock()
+	defer poolsMutex.Unlock()
+
+	if pool, exists := providerPools[name]; exists {
+		return pool
+	}
+
+	maxInFlight := cfg.ProviderMaxConcurrency
+	if maxInFlight <= 0 {
+		maxInFlight = 1
+	}
+
+	limit := rate.Inf
+	burst := 0
+	if cfg.ProviderRateLimitPerSecond > 0 {
+		limit = rate.Limit(cfg.ProviderRateLimitPerSecond)
+		burst = maxInFlight
+	}
+
+	pool := &ProviderPool{
+		slots:   make(chan struct{}, maxInFlight),
+		limiter: rate.NewLimiter(limit, burst),
+	}
+	providerPools[name] = pool
+	return pool
+}
+
+// upstreamCalls returns how many upstream requests embedConcurrently makes
+// to embed count texts with provider
+func upstreamCalls(provider EmbeddingProvider, count int) int {
+	if sizer, ok := provider.(BatchSizer); ok && sizer.BatchSize() > 0 && count > sizer.BatchSize() {
+		return (count + sizer.BatchSize() - 1) / sizer.BatchSize()
+	}
+	return 1
+}
+
+// embedConcurrently splits texts into the provider's batch size and embeds
+// the batches in parallel through pool, preserving input order. The first
+// error cancels the remaining batches and is returned. It also reports how
+// many upstream requests were started.
+func embedConcurrently(ctx context.Context, pool *ProviderPool, provider EmbeddingProvider, texts []string, opts EmbedOptions) ([][]float32, int, error) {
+	batchSize := len(texts)
+	if sizer, ok := provider.(BatchSizer); ok && sizer.BatchSize() > 0 {
+		batchSize = sizer.BatchSize()
+	}
+	if batchSize == 0 || len(texts) <= batchSize {
+		if err := pool.acquire(ctx); err != nil {
+			return nil, 0, err
+		}
+		defer pool.release()
+		embeddings, err := provider.Embed(ctx, texts, opts)
+		return embeddings, 1, err
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	embeddings := make([][]float32, len(texts))
+	calls := 0
+	var (
+		wg       sync.WaitGroup
+		errMutex sync.Mutex
+		firstErr error
+	)
+
+	fail := func(err error) {
+		errMutex.Lock()
+		defer errMutex.Unlock()
+		if firstErr == nil {
+			firstErr = err
+			cancel()
+		}
+	}
+
+	for start := 0; start < len(texts); start += batchSize {
+		end := start + batchSize
+		if end > len(texts) {
+			end = len(texts)
+		}
+
+		if err := pool.acquire(ctx); err != nil {
+			fail(err)
+			break
+		}
+
+		calls++
+		wg.Add(1)
+		go func(start, end int) {
+			defer wg.Done()
+			defer pool.release()
+
+			batch, err := provider.Embed(ctx, texts[start:end], opts)
+			if err == nil && len(batch) != end-start {
+				err = newMalformedError(provider.Name(), "returned %d embeddings for %d inputs", len(batch), end-start)
+			}
+			if err != nil {
+				fail(err)
+				return
+			}
+			copy(embeddings[start:end], batch)
+		}(start, end)
+	}
+
+	wg.Wait()
+	if firstErr != nil {
+		return nil, calls, firstErr
+	}
+	return embeddings, calls, nil
+}
+
+// PauseUntil holds back new upstream requests until t, e.g. when the
+// provider reports it is rate limited. Earlier deadlines never shorten an
+// existing pause.
+func (p *ProviderPool) PauseUntil(t time.Time) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	if t.After(p.pausedUntil) {
+		p.pausedUntil = t
+	}
+}
+
+// acquire waits for a free slot, for any pause to end and for the rate
+// limiter, giving up when ctx is done
+func (p *ProviderPool) acquire(ctx context.Context) error {
+	select {
+	case p.slots <- struct{}{}:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+
+	p.mutex.Lock()
+	wait := time.Until(p.pausedUntil)
+	p.mutex.Unlock()
+
+	if wait > 0 {
+		timer := time.NewTimer(wait)
+		defer timer.Stop()
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			<-p.slots
+			return ctx.Err()
+		}
+	}
+
+	if err := p.limiter.Wait(ctx); err != nil {
+		<-p.slots
+		return err
+	}
+	return nil
+}
+
+// release frees a slot taken by acquire
+func (p *ProviderPool) release() {
+	<-p.slots
+}
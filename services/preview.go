@@ -0,0 +1,83 @@
+package services
+
+import (
+	"batch-embedding-api/models"
+	"context"
+	"unicode/utf8"
+)
+
+// PreviewChunks chunks the inputs of req exactly as GenerateEmbeddings would
+// and estimates the upstream cost of embedding them, without embedding
+// anything. Only the semantic strategy calls the provider, to embed the
+// sentences it places boundaries between.
+func (s *EmbeddingService) PreviewChunks(ctx context.Context, req *models.EmbedRequest) (*models.ChunkResponse, error) {
+	prepared, err := s.prepareTexts(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+	model, texts := prepared.model, prepared.texts
+
+	results := make([]models.ChunkResult, 0, len(req.Inputs))
+	next := 0
+	for i, input := range req.Inputs {
+		result := models.ChunkResult{ID: input.ID}
+
+		chunks := prepared.chunksByInput[i]
+		if chunks == nil {
+			result.Chunks = []models.ChunkPreview{{
+				ChunkID:     input.ID,
+				End:         utf8.RuneCountInString(input.Text),
+				Tokens:      model.Tokenizer.Count(texts[next]),
+				TextSnippet: truncateSnippet(input.Text, 200),
+				Source:      input.Source,
+			}}
+			result.Chunks[0].PageStart, result.Chunks[0].PageEnd = inputPageRange(input)
+			next++
+		} else {
+			result.Chunked = true
+			result.Chunks = make([]models.ChunkPreview, 0, len(chunks))
+			for _, chunk := range chunks {
+				result.Chunks = append(result.Chunks, models.ChunkPreview{
+					ChunkID:     chunk.ChunkID,
+					Start:       chunk.Start,
+					End:         chunk.End,
+					Tokens:      model.Tokenizer.Count(texts[next]),
+					TextSnippet: truncateSnippet(chunk.Text, 200),
+					HeadingPath: chunk.HeadingPath,
+					Symbols:     chunk.Symbols,
+					StartLine:   chunk.StartLine,
+					EndLine:     chunk.EndLine,
+					PageStart:   chunk.PageStart,
+					PageEnd:     chunk.PageEnd,
+					Source:      input.Source,
+				})
+				next++
+			}
+		}
+
+		results = append(results, result)
+	}
+
+	// Identical texts are embedded once, with the model's input prefix
+	prefix := model.InputPrefixes[prepared.opts.InputType]
+	seen := make(map[string]bool, len(texts))
+	estimate := models.ChunkEstimate{Texts: len(texts), Provider: model.Primary().Name()}
+	for _, text := range texts {
+		if seen[text] {
+			continue
+		}
+		seen[text] = true
+		estimate.Unique++
+		estimate.PromptTokens += model.Tokenizer.Count(prefix + text)
+	}
+	estimate.UpstreamCalls = upstreamCalls(model.Primary(), estimate.Unique)
+
+	response := &models.ChunkResponse{Results: results, Estimate: estimate}
+	if prepared.sentences.upstreamCalls > 0 {
+		response.Usage = &models.Usage{
+			PromptTokens:  prepared.sentences.promptTokens,
+			UpstreamCalls: prepared.sentences.upstreamCalls,
+		}
+	}
+	return response, nil
+}
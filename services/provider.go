@@ -0,0 +1,153 @@
+package services
+
+import (
+	"batch-embedding-api/config"
+	"context"
+	"fmt"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+)
+
+// EmbeddingProvider is a backend capable of turning text into vectors
+type EmbeddingProvider interface {
+	// Name returns the registry name of the provider (e.g. "ollama")
+	Name() string
+	// Model returns the upstream model identifier used by the provider
+	Model() string
+	// Dimension returns the length of the vectors produced by the provider
+	Dimension() int
+	// MaxInputSize returns the maximum number of characters accepted per input
+	MaxInputSize() int
+	// Embed generates one embedding per text, in the same order as texts.
+	// Implementations must abandon upstream calls when ctx is done.
+	Embed(ctx context.Context, texts []string, opts EmbedOptions) ([][]float32, error)
+}
+
+// Input types accepted in the input_type request field
+const (
+	InputTypeQuery          = "query"
+	InputTypeDocument       = "document"
+	InputTypeClassification = "classification"
+	InputTypeClustering     = "clustering"
+)
+
+// SupportedInputTypes lists the values accepted for input_type
+var SupportedInputTypes = []string{InputTypeQuery, InputTypeDocument, InputTypeClassification, InputTypeClustering}
+
+// IsSupportedInputType reports whether inputType is empty or a known input_type
+func IsSupportedInputType(inputType string) bool {
+	if inputType == "" {
+		return true
+	}
+	for _, supported := range SupportedInputTypes {
+		if inputType == supported {
+			return true
+		}
+	}
+	return false
+}
+
+// EmbedOptions carries per-request hints to providers
+type EmbedOptions struct {
+	// InputType tells models that embed queries and documents differently
+	// what the texts are; empty when the client did not say
+	InputType string
+}
+
+// ProviderSpec describes the model a provider instance serves
+type ProviderSpec struct {
+	UpstreamModel  string   // empty selects the provider's configured default
+	Endpoints      []string // empty selects the provider's configured endpoints
+	APIKeys        []string // keys for Endpoints: one for all or one per endpoint
+	Dimension      int
+	MaxInputLength int
+}
+
+// ProviderFactory builds a provider from the application configuration
+// and the model it should serve
+type ProviderFactory func(cfg *config.Config, spec ProviderSpec) (EmbeddingProvider, error)
+
+var (
+	providerRegistry = make(map[string]ProviderFactory)
+	registryMutex    sync.RWMutex
+)
+
+// RegisterProvider makes a provider available under the given name.
+// It is intended to be called from init functions; registering the same
+// name twice panics.
+func RegisterProvider(name string, factory ProviderFactory) {
+	registryMutex.Lock()
+	defer registryMutex.Unlock()
+
+	if factory == nil {
+		panic("services: RegisterProvider factory is nil for " + name)
+	}
+	if _, exists := providerRegistry[name]; exists {
+		panic("services: RegisterProvider called twice for " + name)
+	}
+	providerRegistry[name] = factory
+}
+
+// NewProvider instantiates the provider registered under name
+func NewProvider(name string, cfg *config.Config, spec ProviderSpec) (EmbeddingProvider, error) {
+	registryMutex.RLock()
+	factory, exists := providerRegistry[name]
+	registryMutex.RUnlock()
+
+	if !exists {
+		return nil, fmt.Errorf("unknown embedding provider %q (registered: %v)", name, RegisteredProviders())
+	}
+	return factory(cfg, spec)
+}
+
+// RegisteredProviders returns the sorted names of all registered providers
+func RegisteredProviders() []string {
+	registryMutex.RLock()
+	defer registryMutex.RUnlock()
+
+	names := make([]string, 0, len(providerRegistry))
+	for name := range providerRegistry {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// upstreamTransport is shared by all provider HTTP clients so connections to
+// model servers are pooled and reused across requests
+var upstreamTransport = &http.Transport{
+	Proxy:               http.ProxyFromEnvironment,
+	MaxIdleConns:        100,
+	MaxIdleConnsPerHost: 32,
+	IdleConnTimeout:     90 * time.Second,
+}
+
+// newUpstreamClient returns an HTTP client using the shared connection pool
+func newUpstreamClient(timeout time.Duration) *http.Client {
+	return &http.Client{Transport: upstreamTransport, Timeout: timeout}
+}
+
+// embedInBatches splits texts into groups of at most batchSize and embeds
+// each group with embed, preserving input order
+func embedInBatches(ctx context.Context, texts []string, batchSize int, opts EmbedOptions, embed func(ctx context.Context, batch []string, opts EmbedOptions) ([][]float32, error)) ([][]float32, error) {
+	if batchSize <= 0 {
+		batchSize = len(texts)
+	}
+
+	embeddings := make([][]float32, 0, len(texts))
+	for start := 0; start < len(texts); start += batchSize {
+		end := start + batchSize
+		if end > len(texts) {
+			end = len(texts)
+		}
+
+		batch, err := embed(ctx, texts[start:end], opts)
+		if err != nil {
+			return nil, err
+		}
+		embeddings = append(embeddings, batch...)
+	}
+	return embeddings, nil
+}
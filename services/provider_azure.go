@@ -0,0 +1,258 @@
+package services
+
+import (
+	"batch-embedding-api/config"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+func init() {
+	RegisterProvider("azure-openai", newAzureOpenAIProvider)
+}
+
+// defaultAzureRetryAfter is used when Azure throttles without saying for how long
+const defaultAzureRetryAfter = 10 * time.Second
+
+// azureRegion is one Azure OpenAI resource serving the deployment
+type azureRegion struct {
+	endpoint       string
+	apiKey         string
+	mutex          sync.Mutex
+	throttledUntil time.Time
+}
+
+// throttle marks the region as rate limited until t
+func (r *azureRegion) throttle(t time.Time) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	if t.After(r.throttledUntil) {
+		r.throttledUntil = t
+	}
+}
+
+// availableAt returns when the region accepts requests again
+func (r *azureRegion) availableAt() time.Time {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	return r.throttledUntil
+}
+
+// AzureOpenAIProvider generates embeddings using Azure OpenAI deployments,
+// failing over between regions and pausing the provider's request pool
+// when every region is rate limited
+type AzureOpenAIProvider struct {
+	regions      []*azureRegion
+	deployment   string
+	apiVersion   string
+	dimensions   int
+	dimension    int
+	batchSize    int
+	maxInputSize int
+	client       *http.Client
+	pool         *ProviderPool
+}
+
+func newAzureOpenAIProvider(cfg *config.Config, spec ProviderSpec) (EmbeddingProvider, error) {
+	endpoints := spec.Endpoints
+	if len(endpoints) == 0 {
+		endpoints = cfg.AzureOpenAIEndpoints
+	}
+	if len(endpoints) == 0 {
+		return nil, fmt.Errorf("AZURE_OPENAI_ENDPOINTS is required when using the azure-openai provider")
+	}
+	if len(spec.APIKeys) > 1 && len(spec.APIKeys) != len(endpoints) {
+		return nil, fmt.Errorf("azure-openai api_keys must hold one key or one per endpoint, got %d for %d endpoints", len(spec.APIKeys), len(endpoints))
+	}
+	if len(cfg.AzureOpenAIAPIKeys) == 0 && len(spec.APIKeys) == 0 {
+		return nil, fmt.Errorf("AZURE_OPENAI_API_KEYS is required when using the azure-openai provider")
+	}
+
+	// The registry's upstream model is the deployment name
+	deployment := spec.UpstreamModel
+	if deployment == "" {
+		deployment = cfg.AzureOpenAIDeployment
+	}
+	if deployment == "" {
+		return nil, fmt.Errorf("AZURE_OPENAI_DEPLOYMENT or an upstream_model is required when using the azure-openai provider")
+	}
+
+	regions := make([]*azureRegion, 0, len(endpoints))
+	for i, endpoint := range endpoints {
+		regions = append(regions, &azureRegion{
+			endpoint: strings.TrimRight(endpoint, "/"),
+			apiKey:   azureAPIKey(cfg, spec, i, endpoint),
+		})
+	}
+
+	dimensions := 0
+	if cfg.AzureOpenAISendDimensions {
+		dimensions = spec.Dimension
+	}
+
+	return &AzureOpenAIProvider{
+		regions:      regions,
+		deployment:   deployment,
+		apiVersion:   cfg.AzureOpenAIAPIVersion,
+		dimensions:   dimensions,
+		dimension:    spec.Dimension,
+		batchSize:    cfg.AzureOpenAIBatchSize,
+		maxInputSize: spec.MaxInputLength,
+		client:       newUpstreamClient(60 * time.Second),
+		pool:         poolForProvider("azure-openai", cfg),
+	}, nil
+}
+
+// azureAPIKey returns the key for endpoint, the i-th endpoint of the
+// provider. A model's api_keys hold one key for all of its endpoints or one
+// per endpoint. Otherwise AZURE_OPENAI_API_KEYS holds either one key for
+// every endpoint or one key per entry of AZURE_OPENAI_ENDPOINTS, in the same
+// order, so a model's own endpoints get the key of the same endpoint there.
+func azureAPIKey(cfg *config.Config, spec ProviderSpec, i int, endpoint string) string {
+	switch {
+	case len(spec.APIKeys) == 1:
+		return spec.APIKeys[0]
+	case len(spec.APIKeys) > 1:
+		return spec.APIKeys[i]
+	}
+	if len(cfg.AzureOpenAIAPIKeys) == len(cfg.AzureOpenAIEndpoints) {
+		for i, configured := range cfg.AzureOpenAIEndpoints {
+			if configured == endpoint {
+				return cfg.AzureOpenAIAPIKeys[i]
+			}
+		}
+	}
+	return cfg.AzureOpenAIAPIKeys[0]
+}
+
+// Name returns the provider name
+func (p *AzureOpenAIProvider) Name() string { return "azure-openai" }
+
+// Model returns the deployment name
+func (p *AzureOpenAIProvider) Model() string { return p.deployment }
+
+// Dimension returns the embedding dimension
+func (p *AzureOpenAIProvider) Dimension() int { return p.dimension }
+
+// MaxInputSize returns the maximum input length in characters
+func (p *AzureOpenAIProvider) MaxInputSize() int { return p.maxInputSize }
+
+// BatchSize returns the maximum number of inputs per upstream request
+func (p *AzureOpenAIProvider) BatchSize() int { return p.batchSize }
+
+// Embed generates an embedding for each text, sending at most batchSize
+// inputs per upstream request
+func (p *AzureOpenAIProvider) Embed(ctx context.Context, texts []string, opts EmbedOptions) ([][]float32, error) {
+	return embedInBatches(ctx, texts, p.batchSize, opts, p.embedBatch)
+}
+
+// AzureOpenAIEmbedRequest represents the request to an Azure OpenAI
+// embeddings deployment; the model is implied by the deployment
+type AzureOpenAIEmbedRequest struct {
+	Input      []string `json:"input"`
+	Dimensions int      `json:"dimensions,omitempty"`
+}
+
+// embedBatch sends texts to the first region that is not rate limited,
+// moving on to the next region on throttling, timeouts and server errors
+func (p *AzureOpenAIProvider) embedBatch(ctx context.Context, texts []string, opts EmbedOptions) ([][]float32, error) {
+	jsonData, err := json.Marshal(AzureOpenAIEmbedRequest{Input: texts, Dimensions: p.dimensions})
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal request: %w", err)
+	}
+
+	var lastErr error
+	for _, region := range p.regions {
+		if time.Now().Before(region.availableAt()) {
+			continue
+		}
+
+		embeddings, err := p.embedInRegion(ctx, region, jsonData, len(texts))
+		if err == nil {
+			return embeddings, nil
+		}
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+
+		var providerErr *ProviderError
+		retryable := errors.As(err, &providerErr) && (providerErr.StatusCode == http.StatusTooManyRequests ||
+			providerErr.Code == ErrCodeUpstreamTimeout || providerErr.Code == ErrCodeUpstreamServerError)
+		if !retryable {
+			return nil, err
+		}
+		lastErr = err
+	}
+
+	// Every region is throttled: hold back the pool until the first one recovers
+	earliest := time.Time{}
+	for _, region := range p.regions {
+		if at := region.availableAt(); earliest.IsZero() || at.Before(earliest) {
+			earliest = at
+		}
+	}
+	if earliest.After(time.Now()) {
+		p.pool.PauseUntil(earliest)
+	}
+
+	if lastErr != nil {
+		return nil, lastErr
+	}
+	throttled := newStatusError(p.Name(), http.StatusTooManyRequests, "all regions are rate limited")
+	throttled.Throttled = true
+	return nil, throttled
+}
+
+// embedInRegion sends one embeddings request to region and applies its
+// rate-limit headers
+func (p *AzureOpenAIProvider) embedInRegion(ctx context.Context, region *azureRegion, body []byte, count int) ([][]float32, error) {
+	endpoint := fmt.Sprintf("%s/openai/deployments/%s/embeddings?api-version=%s",
+		region.endpoint, url.PathEscape(p.deployment), url.QueryEscape(p.apiVersion))
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, bytes.NewReader(body))
+	if err != nil {
+		return nil, fmt.Errorf("failed to build Azure OpenAI request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("api-key", region.apiKey)
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return nil, newTransportError(p.Name(), err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusTooManyRequests {
+		region.throttle(time.Now().Add(azureRetryAfter(resp.Header)))
+	} else if resp.Header.Get("x-ratelimit-remaining-requests") == "0" || resp.Header.Get("x-ratelimit-remaining-tokens") == "0" {
+		// The quota is used up; stop sending here before Azure starts rejecting
+		region.throttle(time.Now().Add(azureRetryAfter(resp.Header)))
+	}
+
+	return decodeOpenAIResponse(p.Name(), resp, count)
+}
+
+// azureRetryAfter reads how long Azure asks clients to back off
+func azureRetryAfter(header http.Header) time.Duration {
+	if ms, err := strconv.Atoi(header.Get("retry-after-ms")); err == nil && ms > 0 {
+		return time.Duration(ms) * time.Millisecond
+	}
+	if seconds, err := strconv.Atoi(header.Get("retry-after")); err == nil && seconds > 0 {
+		return time.Duration(seconds) * time.Second
+	}
+	for _, key := range []string{"x-ratelimit-reset-requests", "x-ratelimit-reset-tokens"} {
+		if reset, err := time.ParseDuration(header.Get(key)); err == nil && reset > 0 {
+			return reset
+		}
+	}
+	return defaultAzureRetryAfter
+}
@@ -0,0 +1,155 @@
+package services
+
+import (
+	"batch-embedding-api/config"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+)
+
+func init() {
+	RegisterProvider("cohere", newCohereProvider)
+}
+
+const defaultCohereBaseURL = "https://api.cohere.com"
+
+// cohereInputTypes maps API input types to Cohere's input_type values
+var cohereInputTypes = map[string]string{
+	InputTypeQuery:          "search_query",
+	InputTypeDocument:       "search_document",
+	InputTypeClassification: "classification",
+	InputTypeClustering:     "clustering",
+}
+
+// CohereProvider generates embeddings using the Cohere v2 embed API or a
+// compatible endpoint
+type CohereProvider struct {
+	apiKey       string
+	baseURL      string
+	model        string
+	dimension    int
+	batchSize    int
+	maxInputSize int
+	client       *http.Client
+}
+
+func newCohereProvider(cfg *config.Config, spec ProviderSpec) (EmbeddingProvider, error) {
+	if cfg.CohereAPIKey == "" && cfg.CohereBaseURL == defaultCohereBaseURL {
+		return nil, fmt.Errorf("COHERE_API_KEY is required when using the cohere provider")
+	}
+
+	model := spec.UpstreamModel
+	if model == "" {
+		model = cfg.CohereModel
+	}
+
+	return &CohereProvider{
+		apiKey:       cfg.CohereAPIKey,
+		baseURL:      cfg.CohereBaseURL,
+		model:        model,
+		dimension:    spec.Dimension,
+		batchSize:    cfg.CohereBatchSize,
+		maxInputSize: spec.MaxInputLength,
+		client:       newUpstreamClient(60 * time.Second),
+	}, nil
+}
+
+// Name returns the provider name
+func (p *CohereProvider) Name() string { return "cohere" }
+
+// Model returns the upstream model name
+func (p *CohereProvider) Model() string { return p.model }
+
+// Dimension returns the embedding dimension
+func (p *CohereProvider) Dimension() int { return p.dimension }
+
+// MaxInputSize returns the maximum input length in characters
+func (p *CohereProvider) MaxInputSize() int { return p.maxInputSize }
+
+// BatchSize returns the maximum number of inputs per upstream request
+func (p *CohereProvider) BatchSize() int { return p.batchSize }
+
+// Embed generates an embedding for each text, sending at most batchSize
+// inputs per upstream request
+func (p *CohereProvider) Embed(ctx context.Context, texts []string, opts EmbedOptions) ([][]float32, error) {
+	return embedInBatches(ctx, texts, p.batchSize, opts, p.embedBatch)
+}
+
+// CohereEmbedRequest represents the request to the Cohere /v2/embed endpoint
+type CohereEmbedRequest struct {
+	Model          string   `json:"model"`
+	Texts          []string `json:"texts"`
+	InputType      string   `json:"input_type"`
+	EmbeddingTypes []string `json:"embedding_types"`
+}
+
+// CohereEmbedResponse represents the response from the Cohere /v2/embed endpoint
+type CohereEmbedResponse struct {
+	Embeddings struct {
+		Float [][]float32 `json:"float"`
+	} `json:"embeddings"`
+}
+
+// CohereErrorResponse represents an error body returned by Cohere
+type CohereErrorResponse struct {
+	Message string `json:"message"`
+}
+
+// embedBatch calls the Cohere embed API for a batch of texts
+func (p *CohereProvider) embedBatch(ctx context.Context, texts []string, opts EmbedOptions) ([][]float32, error) {
+	// Cohere requires an input type; unlabeled texts are treated as documents
+	inputType := cohereInputTypes[opts.InputType]
+	if inputType == "" {
+		inputType = cohereInputTypes[InputTypeDocument]
+	}
+
+	reqBody := CohereEmbedRequest{
+		Model:          p.model,
+		Texts:          texts,
+		InputType:      inputType,
+		EmbeddingTypes: []string{"float"},
+	}
+
+	jsonData, err := json.Marshal(reqBody)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal request: %w", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, p.baseURL+"/v2/embed", bytes.NewReader(jsonData))
+	if err != nil {
+		return nil, fmt.Errorf("failed to build Cohere request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if p.apiKey != "" {
+		req.Header.Set("Authorization", "Bearer "+p.apiKey)
+	}
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return nil, newTransportError(p.Name(), err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(resp.Body)
+		var errResp CohereErrorResponse
+		if json.Unmarshal(body, &errResp) == nil && errResp.Message != "" {
+			return nil, newStatusError(p.Name(), resp.StatusCode, errResp.Message)
+		}
+		return nil, newStatusError(p.Name(), resp.StatusCode, string(body))
+	}
+
+	var cohereResp CohereEmbedResponse
+	if err := json.NewDecoder(resp.Body).Decode(&cohereResp); err != nil {
+		return nil, newMalformedError(p.Name(), "failed to decode response: %w", err)
+	}
+	if len(cohereResp.Embeddings.Float) != len(texts) {
+		return nil, newMalformedError(p.Name(), "returned %d embeddings for %d inputs", len(cohereResp.Embeddings.Float), len(texts))
+	}
+
+	return cohereResp.Embeddings.Float, nil
+}
@@ -0,0 +1,66 @@
+package services
+
+import (
+	"batch-embedding-api/config"
+	"context"
+	"math/rand"
+)
+
+func init() {
+	RegisterProvider("mock", newMockProvider)
+}
+
+// MockProvider generates deterministic pseudo-random embeddings without a model server
+type MockProvider struct {
+	dimension    int
+	maxInputSize int
+}
+
+func newMockProvider(cfg *config.Config, spec ProviderSpec) (EmbeddingProvider, error) {
+	return &MockProvider{
+		dimension:    spec.Dimension,
+		maxInputSize: spec.MaxInputLength,
+	}, nil
+}
+
+// Name returns the provider name
+func (p *MockProvider) Name() string { return "mock" }
+
+// Model returns the upstream model name
+func (p *MockProvider) Model() string { return "mock" }
+
+// Dimension returns the embedding dimension
+func (p *MockProvider) Dimension() int { return p.dimension }
+
+// MaxInputSize returns the maximum input length in characters
+func (p *MockProvider) MaxInputSize() int { return p.maxInputSize }
+
+// Embed generates a mock embedding for each text
+func (p *MockProvider) Embed(ctx context.Context, texts []string, opts EmbedOptions) ([][]float32, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	embeddings := make([][]float32, len(texts))
+	for i, text := range texts {
+		embeddings[i] = mockEmbedding(text, p.dimension)
+	}
+	return embeddings, nil
+}
+
+// mockEmbedding generates a deterministic mock embedding based on text
+func mockEmbedding(text string, dimension int) []float32 {
+	// Use text hash as seed for reproducibility
+	seed := int64(0)
+	for _, r := range text {
+		seed = seed*31 + int64(r)
+	}
+	rng := rand.New(rand.NewSource(seed))
+
+	embedding := make([]float32, dimension)
+	for i := range embedding {
+		embedding[i] = rng.Float32()*2 - 1 // Range [-1, 1]
+	}
+
+	return embedding
+}
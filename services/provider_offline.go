@@ -0,0 +1,187 @@
+package services
+
+import (
+	"batch-embedding-api/config"
+	"context"
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"math"
+	"os"
+	"strings"
+	"unicode"
+)
+
+func init() {
+	RegisterProvider("offline", newOfflineProvider)
+}
+
+// Feature weights relative to a word unigram
+const (
+	offlineBigramWeight    = 0.5
+	offlineCharGramWeight  = 1.2
+	offlineMinCharGram     = 3
+	offlineMaxCharGram     = 4
+	offlineStopwordIDF     = 0.1
+	offlineDefaultTermIDF  = 1.0
+	offlineCharGramPadding = "#"
+)
+
+// offlineStopwords are down-weighted when no IDF table is configured
+var offlineStopwords = map[string]bool{
+	"a": true, "an": true, "and": true, "are": true, "as": true, "at": true, "be": true,
+	"by": true, "for": true, "from": true, "has": true, "in": true, "is": true, "it": true,
+	"its": true, "of": true, "on": true, "or": true, "that": true, "the": true, "this": true,
+	"to": true, "was": true, "were": true, "will": true, "with": true,
+}
+
+// OfflineProvider produces lexical embeddings without a model server by
+// hashing IDF-weighted word unigrams, word bigrams and character n-grams
+// into a fixed number of buckets. Texts sharing words or word stems get a
+// high cosine similarity, which makes it useful for tests and air-gapped
+// demos; it has no notion of synonyms.
+type OfflineProvider struct {
+	dimension    int
+	maxInputSize int
+	idf          map[string]float64
+	defaultIDF   float64
+}
+
+func newOfflineProvider(cfg *config.Config, spec ProviderSpec) (EmbeddingProvider, error) {
+	if spec.Dimension <= 0 {
+		return nil, fmt.Errorf("offline provider requires a positive dimension")
+	}
+
+	provider := &OfflineProvider{
+		dimension:    spec.Dimension,
+		maxInputSize: spec.MaxInputLength,
+		defaultIDF:   offlineDefaultTermIDF,
+	}
+
+	if cfg.OfflineIDFPath != "" {
+		idf, err := loadOfflineIDF(cfg.OfflineIDFPath)
+		if err != nil {
+			return nil, err
+		}
+		provider.idf = idf
+
+		// Terms missing from the table are treated as the rarest seen
+		provider.defaultIDF = 0
+		for _, weight := range idf {
+			provider.defaultIDF = math.Max(provider.defaultIDF, weight)
+		}
+	}
+
+	return provider, nil
+}
+
+// loadOfflineIDF reads a JSON object mapping lowercase terms to IDF weights
+func loadOfflineIDF(path string) (map[string]float64, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read offline IDF table: %w", err)
+	}
+
+	var idf map[string]float64
+	if err := json.Unmarshal(data, &idf); err != nil {
+		return nil, fmt.Errorf("failed to parse offline IDF table %s: %w", path, err)
+	}
+	return idf, nil
+}
+
+// Name returns the provider name
+func (p *OfflineProvider) Name() string { return "offline" }
+
+// Model returns the upstream model name
+func (p *OfflineProvider) Model() string { return "feature-hashing" }
+
+// Dimension returns the embedding dimension
+func (p *OfflineProvider) Dimension() int { return p.dimension }
+
+// MaxInputSize returns the maximum input length in characters
+func (p *OfflineProvider) MaxInputSize() int { return p.maxInputSize }
+
+// Embed generates a hashed feature vector for each text
+func (p *OfflineProvider) Embed(ctx context.Context, texts []string, opts EmbedOptions) ([][]float32, error) {
+	embeddings := make([][]float32, len(texts))
+	for i, text := range texts {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		embeddings[i] = p.embed(text)
+	}
+	return embeddings, nil
+}
+
+// embed builds the L2-normalized feature vector of a single text
+func (p *OfflineProvider) embed(text string) []float32 {
+	words := strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsNumber(r)
+	})
+
+	vec := make([]float64, p.dimension)
+	for i, word := range words {
+		weight := p.termIDF(word)
+		p.addFeature(vec, "w:"+word, weight)
+
+		if i > 0 {
+			prevWeight := p.termIDF(words[i-1])
+			p.addFeature(vec, "b:"+words[i-1]+" "+word, offlineBigramWeight*(weight+prevWeight)/2)
+		}
+
+		// Character n-grams make inflections such as "cat"/"cats" overlap
+		runes := []rune(offlineCharGramPadding + word + offlineCharGramPadding)
+		var grams []string
+		for n := offlineMinCharGram; n <= offlineMaxCharGram; n++ {
+			for start := 0; start+n <= len(runes); start++ {
+				grams = append(grams, string(runes[start:start+n]))
+			}
+		}
+		for _, gram := range grams {
+			p.addFeature(vec, "c:"+gram, offlineCharGramWeight*weight/math.Sqrt(float64(len(grams))))
+		}
+	}
+
+	var sumSq float64
+	for _, v := range vec {
+		sumSq += v * v
+	}
+	norm := math.Sqrt(sumSq)
+
+	embedding := make([]float32, p.dimension)
+	if norm == 0 {
+		return embedding
+	}
+	for i, v := range vec {
+		embedding[i] = float32(v / norm)
+	}
+	return embedding
+}
+
+// termIDF returns the inverse document frequency weight of a word
+func (p *OfflineProvider) termIDF(word string) float64 {
+	if p.idf != nil {
+		if weight, ok := p.idf[word]; ok {
+			return weight
+		}
+		return p.defaultIDF
+	}
+	if offlineStopwords[word] {
+		return offlineStopwordIDF
+	}
+	return p.defaultIDF
+}
+
+// addFeature hashes a feature into a bucket with a hash-derived sign, so
+// that collisions cancel out on average instead of accumulating
+func (p *OfflineProvider) addFeature(vec []float64, feature string, weight float64) {
+	hasher := fnv.New64a()
+	hasher.Write([]byte(feature))
+	sum := hasher.Sum64()
+
+	index := int(sum % uint64(len(vec)))
+	if sum>>63 == 1 {
+		weight = -weight
+	}
+	vec[index] += weight
+}
@@ -0,0 +1,117 @@
+package services
+
+import (
+	"batch-embedding-api/config"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+)
+
+func init() {
+	RegisterProvider("ollama", newOllamaProvider)
+}
+
+// OllamaProvider generates embeddings using a local Ollama server
+type OllamaProvider struct {
+	url          string
+	model        string
+	dimension    int
+	batchSize    int
+	maxInputSize int
+	client       *http.Client
+}
+
+func newOllamaProvider(cfg *config.Config, spec ProviderSpec) (EmbeddingProvider, error) {
+	model := spec.UpstreamModel
+	if model == "" {
+		model = cfg.OllamaModel
+	}
+
+	return &OllamaProvider{
+		url:          cfg.OllamaURL,
+		model:        model,
+		dimension:    spec.Dimension,
+		batchSize:    cfg.OllamaBatchSize,
+		maxInputSize: spec.MaxInputLength,
+		client:       newUpstreamClient(60 * time.Second),
+	}, nil
+}
+
+// Name returns the provider name
+func (p *OllamaProvider) Name() string { return "ollama" }
+
+// Model returns the upstream model name
+func (p *OllamaProvider) Model() string { return p.model }
+
+// Dimension returns the embedding dimension
+func (p *OllamaProvider) Dimension() int { return p.dimension }
+
+// MaxInputSize returns the maximum input length in characters
+func (p *OllamaProvider) MaxInputSize() int { return p.maxInputSize }
+
+// BatchSize returns the maximum number of inputs per upstream request
+func (p *OllamaProvider) BatchSize() int { return p.batchSize }
+
+// Embed generates an embedding for each text, sending at most batchSize
+// inputs per upstream request
+func (p *OllamaProvider) Embed(ctx context.Context, texts []string, opts EmbedOptions) ([][]float32, error) {
+	return embedInBatches(ctx, texts, p.batchSize, opts, p.embedBatch)
+}
+
+// OllamaEmbedRequest represents the request to the Ollama /api/embed endpoint
+type OllamaEmbedRequest struct {
+	Model string   `json:"model"`
+	Input []string `json:"input"`
+}
+
+// OllamaEmbedResponse represents the response from the Ollama /api/embed endpoint
+type OllamaEmbedResponse struct {
+	Model      string      `json:"model"`
+	Embeddings [][]float32 `json:"embeddings"`
+}
+
+// embedBatch calls the Ollama API to embed a batch of texts
+func (p *OllamaProvider) embedBatch(ctx context.Context, texts []string, opts EmbedOptions) ([][]float32, error) {
+	reqBody := OllamaEmbedRequest{
+		Model: p.model,
+		Input: texts,
+	}
+
+	jsonData, err := json.Marshal(reqBody)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal request: %w", err)
+	}
+
+	url := fmt.Sprintf("%s/api/embed", p.url)
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(jsonData))
+	if err != nil {
+		return nil, fmt.Errorf("failed to build Ollama request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return nil, newTransportError(p.Name(), err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(resp.Body)
+		return nil, newStatusError(p.Name(), resp.StatusCode, string(body))
+	}
+
+	var ollamaResp OllamaEmbedResponse
+	if err := json.NewDecoder(resp.Body).Decode(&ollamaResp); err != nil {
+		return nil, newMalformedError(p.Name(), "failed to decode response: %w", err)
+	}
+	if len(ollamaResp.Embeddings) != len(texts) {
+		return nil, newMalformedError(p.Name(), "returned %d embeddings for %d inputs", len(ollamaResp.Embeddings), len(texts))
+	}
+
+	return ollamaResp.Embeddings, nil
+}
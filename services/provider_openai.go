@@ -0,0 +1,183 @@
+package services
+
+import (
+	"batch-embedding-api/config"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+)
+
+func init() {
+	RegisterProvider("openai", newOpenAIProvider)
+}
+
+const defaultOpenAIBaseURL = "https://api.openai.com/v1"
+
+// OpenAIProvider generates embeddings using the OpenAI API or any
+// OpenAI-compatible /embeddings endpoint
+type OpenAIProvider struct {
+	apiKey       string
+	baseURL      string
+	model        string
+	dimensions   int
+	dimension    int
+	batchSize    int
+	maxInputSize int
+	client       *http.Client
+}
+
+func newOpenAIProvider(cfg *config.Config, spec ProviderSpec) (EmbeddingProvider, error) {
+	if cfg.OpenAIAPIKey == "" && cfg.OpenAIBaseURL == defaultOpenAIBaseURL {
+		return nil, fmt.Errorf("OPENAI_API_KEY is required when using the openai provider")
+	}
+
+	model := spec.UpstreamModel
+	if model == "" {
+		model = cfg.OpenAIEmbeddingModel
+	}
+
+	// Models without configurable output size reject the dimensions parameter
+	dimensions := 0
+	if cfg.OpenAISendDimensions {
+		dimensions = spec.Dimension
+	}
+
+	batchSize := cfg.OpenAIBatchSize
+	if batchSize <= 0 {
+		batchSize = 2048
+	}
+
+	return &OpenAIProvider{
+		apiKey:       cfg.OpenAIAPIKey,
+		baseURL:      cfg.OpenAIBaseURL,
+		model:        model,
+		dimensions:   dimensions,
+		dimension:    spec.Dimension,
+		batchSize:    batchSize,
+		maxInputSize: spec.MaxInputLength,
+		client:       newUpstreamClient(60 * time.Second),
+	}, nil
+}
+
+// Name returns the provider name
+func (p *OpenAIProvider) Name() string { return "openai" }
+
+// Model returns the upstream model name
+func (p *OpenAIProvider) Model() string { return p.model }
+
+// Dimension returns the embedding dimension
+func (p *OpenAIProvider) Dimension() int { return p.dimension }
+
+// MaxInputSize returns the maximum input length in characters
+func (p *OpenAIProvider) MaxInputSize() int { return p.maxInputSize }
+
+// BatchSize returns the maximum number of inputs per upstream request
+func (p *OpenAIProvider) BatchSize() int { return p.batchSize }
+
+// Embed generates an embedding for each text, sending at most batchSize
+// inputs per upstream request
+func (p *OpenAIProvider) Embed(ctx context.Context, texts []string, opts EmbedOptions) ([][]float32, error) {
+	return embedInBatches(ctx, texts, p.batchSize, opts, p.embedBatch)
+}
+
+// OpenAIEmbedRequest represents the request to the OpenAI embeddings API
+type OpenAIEmbedRequest struct {
+	Model          string   `json:"model"`
+	Input          []string `json:"input"`
+	Dimensions     int      `json:"dimensions,omitempty"`
+	EncodingFormat string   `json:"encoding_format"`
+}
+
+// OpenAIEmbedResponse represents the response from the OpenAI embeddings API
+type OpenAIEmbedResponse struct {
+	Data []struct {
+		Index     int       `json:"index"`
+		Embedding []float32 `json:"embedding"`
+	} `json:"data"`
+	Model string `json:"model"`
+	Usage struct {
+		PromptTokens int `json:"prompt_tokens"`
+		TotalTokens  int `json:"total_tokens"`
+	} `json:"usage"`
+}
+
+// OpenAIErrorResponse represents an error body returned by the OpenAI API
+type OpenAIErrorResponse struct {
+	Error struct {
+		Message string `json:"message"`
+		Type    string `json:"type"`
+		Code    string `json:"code"`
+	} `json:"error"`
+}
+
+// embedBatch sends a single /embeddings request for texts
+func (p *OpenAIProvider) embedBatch(ctx context.Context, texts []string, opts EmbedOptions) ([][]float32, error) {
+	reqBody := OpenAIEmbedRequest{
+		Model:          p.model,
+		Input:          texts,
+		Dimensions:     p.dimensions,
+		EncodingFormat: "float",
+	}
+
+	jsonData, err := json.Marshal(reqBody)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal request: %w", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, p.baseURL+"/embeddings", bytes.NewReader(jsonData))
+	if err != nil {
+		return nil, fmt.Errorf("failed to build OpenAI request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if p.apiKey != "" {
+		req.Header.Set("Authorization", "Bearer "+p.apiKey)
+	}
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return nil, newTransportError(p.Name(), err)
+	}
+	defer resp.Body.Close()
+
+	return decodeOpenAIResponse(p.Name(), resp, len(texts))
+}
+
+// decodeOpenAIResponse turns an OpenAI-format /embeddings response into one
+// vector per input, classifying error responses
+func decodeOpenAIResponse(provider string, resp *http.Response, count int) ([][]float32, error) {
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(resp.Body)
+		var errResp OpenAIErrorResponse
+		if json.Unmarshal(body, &errResp) == nil && errResp.Error.Message != "" {
+			return nil, newStatusError(provider, resp.StatusCode, errResp.Error.Message)
+		}
+		return nil, newStatusError(provider, resp.StatusCode, string(body))
+	}
+
+	var openaiResp OpenAIEmbedResponse
+	if err := json.NewDecoder(resp.Body).Decode(&openaiResp); err != nil {
+		return nil, newMalformedError(provider, "failed to decode response: %w", err)
+	}
+
+	if len(openaiResp.Data) != count {
+		return nil, newMalformedError(provider, "returned %d embeddings for %d inputs", len(openaiResp.Data), count)
+	}
+
+	// The API does not guarantee ordering, so place each vector by index
+	embeddings := make([][]float32, count)
+	for _, item := range openaiResp.Data {
+		if item.Index < 0 || item.Index >= count || embeddings[item.Index] != nil {
+			return nil, newMalformedError(provider, "returned invalid embedding index %d", item.Index)
+		}
+		if len(item.Embedding) == 0 {
+			return nil, newMalformedError(provider, "returned an empty embedding at index %d", item.Index)
+		}
+		embeddings[item.Index] = item.Embedding
+	}
+
+	return embeddings, nil
+}
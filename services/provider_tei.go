@@ -0,0 +1,145 @@
+package services
+
+import (
+	"batch-embedding-api/config"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+)
+
+func init() {
+	RegisterProvider("tei", newTEIProvider)
+}
+
+// TEIProvider generates embeddings using a Hugging Face Text Embeddings
+// Inference server
+type TEIProvider struct {
+	url                 string
+	apiToken            string
+	model               string
+	dimension           int
+	batchSize           int
+	maxInputSize        int
+	normalize           bool
+	truncate            bool
+	truncationDirection string
+	client              *http.Client
+}
+
+func newTEIProvider(cfg *config.Config, spec ProviderSpec) (EmbeddingProvider, error) {
+	if cfg.TEIURL == "" {
+		return nil, fmt.Errorf("TEI_URL is required when using the tei provider")
+	}
+	if cfg.TEITruncationDirection != "Right" && cfg.TEITruncationDirection != "Left" {
+		return nil, fmt.Errorf("TEI_TRUNCATION_DIRECTION must be 'Right' or 'Left', got %q", cfg.TEITruncationDirection)
+	}
+
+	// TEI serves a single model, so the name is only used for reporting
+	model := spec.UpstreamModel
+	if model == "" {
+		model = cfg.TEIModel
+	}
+
+	return &TEIProvider{
+		url:                 cfg.TEIURL,
+		apiToken:            cfg.TEIAPIToken,
+		model:               model,
+		dimension:           spec.Dimension,
+		batchSize:           cfg.TEIBatchSize,
+		maxInputSize:        spec.MaxInputLength,
+		normalize:           cfg.TEINormalize,
+		truncate:            cfg.TEITruncate,
+		truncationDirection: cfg.TEITruncationDirection,
+		client:              newUpstreamClient(60 * time.Second),
+	}, nil
+}
+
+// Name returns the provider name
+func (p *TEIProvider) Name() string { return "tei" }
+
+// Model returns the upstream model name
+func (p *TEIProvider) Model() string { return p.model }
+
+// Dimension returns the embedding dimension
+func (p *TEIProvider) Dimension() int { return p.dimension }
+
+// MaxInputSize returns the maximum input length in characters
+func (p *TEIProvider) MaxInputSize() int { return p.maxInputSize }
+
+// BatchSize returns the maximum number of inputs per upstream request
+func (p *TEIProvider) BatchSize() int { return p.batchSize }
+
+// Embed generates an embedding for each text, sending at most batchSize
+// inputs per upstream request
+func (p *TEIProvider) Embed(ctx context.Context, texts []string, opts EmbedOptions) ([][]float32, error) {
+	return embedInBatches(ctx, texts, p.batchSize, opts, p.embedBatch)
+}
+
+// TEIEmbedRequest represents the request to the TEI /embed endpoint
+type TEIEmbedRequest struct {
+	Inputs              []string `json:"inputs"`
+	Normalize           bool     `json:"normalize"`
+	Truncate            bool     `json:"truncate"`
+	TruncationDirection string   `json:"truncation_direction,omitempty"`
+}
+
+// TEIErrorResponse represents an error body returned by TEI
+type TEIErrorResponse struct {
+	Error     string `json:"error"`
+	ErrorType string `json:"error_type"`
+}
+
+// embedBatch calls the TEI /embed endpoint for a batch of texts
+func (p *TEIProvider) embedBatch(ctx context.Context, texts []string, opts EmbedOptions) ([][]float32, error) {
+	reqBody := TEIEmbedRequest{
+		Inputs:    texts,
+		Normalize: p.normalize,
+		Truncate:  p.truncate,
+	}
+	if p.truncate {
+		reqBody.TruncationDirection = p.truncationDirection
+	}
+
+	jsonData, err := json.Marshal(reqBody)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal request: %w", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, p.url+"/embed", bytes.NewReader(jsonData))
+	if err != nil {
+		return nil, fmt.Errorf("failed to build TEI request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if p.apiToken != "" {
+		req.Header.Set("Authorization", "Bearer "+p.apiToken)
+	}
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return nil, newTransportError(p.Name(), err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(resp.Body)
+		var errResp TEIErrorResponse
+		if json.Unmarshal(body, &errResp) == nil && errResp.Error != "" {
+			return nil, newStatusError(p.Name(), resp.StatusCode, errResp.Error)
+		}
+		return nil, newStatusError(p.Name(), resp.StatusCode, string(body))
+	}
+
+	var embeddings [][]float32
+	if err := json.NewDecoder(resp.Body).Decode(&embeddings); err != nil {
+		return nil, newMalformedError(p.Name(), "failed to decode response: %w", err)
+	}
+	if len(embeddings) != len(texts) {
+		return nil, newMalformedError(p.Name(), "returned %d embeddings for %d inputs", len(embeddings), len(texts))
+	}
+
+	return embeddings, nil
+}
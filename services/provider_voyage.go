@@ -0,0 +1,150 @@
+package services
+
+import (
+	"batch-embedding-api/config"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+)
+
+func init() {
+	RegisterProvider("voyage", newVoyageProvider)
+}
+
+const defaultVoyageBaseURL = "https://api.voyageai.com/v1"
+
+// VoyageProvider generates embeddings using the Voyage AI embeddings API or
+// a compatible endpoint
+type VoyageProvider struct {
+	apiKey       string
+	baseURL      string
+	model        string
+	dimension    int
+	batchSize    int
+	maxInputSize int
+	client       *http.Client
+}
+
+func newVoyageProvider(cfg *config.Config, spec ProviderSpec) (EmbeddingProvider, error) {
+	if cfg.VoyageAPIKey == "" && cfg.VoyageBaseURL == defaultVoyageBaseURL {
+		return nil, fmt.Errorf("VOYAGE_API_KEY is required when using the voyage provider")
+	}
+
+	model := spec.UpstreamModel
+	if model == "" {
+		model = cfg.VoyageModel
+	}
+
+	return &VoyageProvider{
+		apiKey:       cfg.VoyageAPIKey,
+		baseURL:      cfg.VoyageBaseURL,
+		model:        model,
+		dimension:    spec.Dimension,
+		batchSize:    cfg.VoyageBatchSize,
+		maxInputSize: spec.MaxInputLength,
+		client:       newUpstreamClient(60 * time.Second),
+	}, nil
+}
+
+// Name returns the provider name
+func (p *VoyageProvider) Name() string { return "voyage" }
+
+// Model returns the upstream model name
+func (p *VoyageProvider) Model() string { return p.model }
+
+// Dimension returns the embedding dimension
+func (p *VoyageProvider) Dimension() int { return p.dimension }
+
+// MaxInputSize returns the maximum input length in characters
+func (p *VoyageProvider) MaxInputSize() int { return p.maxInputSize }
+
+// BatchSize returns the maximum number of inputs per upstream request
+func (p *VoyageProvider) BatchSize() int { return p.batchSize }
+
+// Embed generates an embedding for each text, sending at most batchSize
+// inputs per upstream request
+func (p *VoyageProvider) Embed(ctx context.Context, texts []string, opts EmbedOptions) ([][]float32, error) {
+	return embedInBatches(ctx, texts, p.batchSize, opts, p.embedBatch)
+}
+
+// VoyageEmbedRequest represents the request to the Voyage /embeddings endpoint
+type VoyageEmbedRequest struct {
+	Model     string   `json:"model"`
+	Input     []string `json:"input"`
+	InputType string   `json:"input_type,omitempty"` // only "query" and "document" are supported
+}
+
+// VoyageEmbedResponse represents the response from the Voyage /embeddings endpoint
+type VoyageEmbedResponse struct {
+	Data []struct {
+		Index     int       `json:"index"`
+		Embedding []float32 `json:"embedding"`
+	} `json:"data"`
+}
+
+// VoyageErrorResponse represents an error body returned by Voyage
+type VoyageErrorResponse struct {
+	Detail string `json:"detail"`
+}
+
+// embedBatch calls the Voyage embeddings API for a batch of texts
+func (p *VoyageProvider) embedBatch(ctx context.Context, texts []string, opts EmbedOptions) ([][]float32, error) {
+	reqBody := VoyageEmbedRequest{
+		Model: p.model,
+		Input: texts,
+	}
+	if opts.InputType == InputTypeQuery || opts.InputType == InputTypeDocument {
+		reqBody.InputType = opts.InputType
+	}
+
+	jsonData, err := json.Marshal(reqBody)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal request: %w", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, p.baseURL+"/embeddings", bytes.NewReader(jsonData))
+	if err != nil {
+		return nil, fmt.Errorf("failed to build Voyage request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if p.apiKey != "" {
+		req.Header.Set("Authorization", "Bearer "+p.apiKey)
+	}
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return nil, newTransportError(p.Name(), err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(resp.Body)
+		var errResp VoyageErrorResponse
+		if json.Unmarshal(body, &errResp) == nil && errResp.Detail != "" {
+			return nil, newStatusError(p.Name(), resp.StatusCode, errResp.Detail)
+		}
+		return nil, newStatusError(p.Name(), resp.StatusCode, string(body))
+	}
+
+	var voyageResp VoyageEmbedResponse
+	if err := json.NewDecoder(resp.Body).Decode(&voyageResp); err != nil {
+		return nil, newMalformedError(p.Name(), "failed to decode response: %w", err)
+	}
+	if len(voyageResp.Data) != len(texts) {
+		return nil, newMalformedError(p.Name(), "returned %d embeddings for %d inputs", len(voyageResp.Data), len(texts))
+	}
+
+	embeddings := make([][]float32, len(texts))
+	for _, item := range voyageResp.Data {
+		if item.Index < 0 || item.Index >= len(texts) || embeddings[item.Index] != nil {
+			return nil, newMalformedError(p.Name(), "returned invalid embedding index %d", item.Index)
+		}
+		embeddings[item.Index] = item.Embedding
+	}
+
+	return embeddings, nil
+}
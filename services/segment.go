@@ -0,0 +1,161 @@
+package services
+
+import (
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// The segmenters below return the byte offsets inside text[start:end] at
+// which a new paragraph, sentence or word begins. Whitespace separating two
+// segments stays with the first one, so the segments tile the range.
+
+// paragraphBoundaries splits at blank lines
+func paragraphBoundaries(text string, start, end int) []int {
+	var boundaries []int
+	for i := start; i < end; i++ {
+		if text[i] != '\n' {
+			continue
+		}
+
+		// A blank line is a second newline separated only by whitespace
+		j, newlines := i+1, 1
+		for j < end {
+			r, size := utf8.DecodeRuneInString(text[j:end])
+			if !unicode.IsSpace(r) {
+				break
+			}
+			if r == '\n' {
+				newlines++
+			}
+			j += size
+		}
+		if newlines >= 2 && j < end {
+			boundaries = append(boundaries, j)
+		}
+		i = j - 1
+	}
+	return boundaries
+}
+
+// sentenceTerminators end a sentence when followed by whitespace
+const sentenceTerminators = ".!?…‼⁇⁈⁉" +
+	"\u037e" + // Greek question mark
+	"։" + // Armenian
+	"؟۔" + // Arabic question mark, Urdu full stop
+	"।॥" + // Devanagari danda
+	"።፧፨" + // Ethiopic
+	"။" // Myanmar
+
+// fullWidthTerminators end a sentence even without following whitespace,
+// as in Chinese and Japanese text
+const fullWidthTerminators = "。！？｡．"
+
+// sentenceClosers may follow a terminator and belong to the sentence it ends
+const sentenceClosers = "\"')]}’”»›」』）】〉》"
+
+// abbreviations are words whose trailing period does not end a sentence
+var abbreviations = map[string]bool{
+	"mr": true, "mrs": true, "ms": true, "dr": true, "prof": true, "sr": true, "jr": true, "st": true,
+	"mt": true, "gen": true, "col": true, "capt": true, "lt": true, "sgt": true, "rev": true, "hon": true,
+	"vs": true, "etc": true, "e.g": true, "i.e": true, "cf": true, "al": true, "approx": true, "ca": true,
+	"inc": true, "ltd": true, "co": true, "corp": true, "dept": true, "univ": true, "est": true,
+	"no": true, "nos": true, "vol": true, "fig": true, "figs": true, "p": true, "pp": true, "ch": true,
+	"sec": true, "eq": true, "ed": true, "eds": true, "op": true, "cit": true, "ibid": true,
+	"jan": true, "feb": true, "mar": true, "apr": true, "jun": true, "jul": true, "aug": true,
+	"sep": true, "sept": true, "oct": true, "nov": true, "dec": true,
+	"u.s": true, "u.k": true, "a.m": true, "p.m": true, "ph.d": true,
+	"z.b": true, "usw": true, "bzw": true, "ggf": true, "d.h": true,
+}
+
+// sentenceBoundaries splits after sentence-ending punctuation
+func sentenceBoundaries(text string, start, end int) []int {
+	var boundaries []int
+	for i := start; i < end; {
+		r, size := utf8.DecodeRuneInString(text[i:end])
+		fullWidth := strings.ContainsRune(fullWidthTerminators, r)
+		if !fullWidth && !strings.ContainsRune(sentenceTerminators, r) {
+			i += size
+			continue
+		}
+
+		// Consume runs such as "?!" or "..." and any closing quotes
+		j := i + size
+		for j < end {
+			next, nextSize := utf8.DecodeRuneInString(text[j:end])
+			if strings.ContainsRune(sentenceTerminators, next) || strings.ContainsRune(fullWidthTerminators, next) {
+				fullWidth = fullWidth || strings.ContainsRune(fullWidthTerminators, next)
+			} else if !strings.ContainsRune(sentenceClosers, next) {
+				break
+			}
+			j += nextSize
+		}
+
+		// Skip the whitespace that separates this sentence from the next
+		k := j
+		for k < end {
+			next, nextSize := utf8.DecodeRuneInString(text[k:end])
+			if !unicode.IsSpace(next) {
+				break
+			}
+			k += nextSize
+		}
+
+		if k < end && (fullWidth || k > j) && !continuesSentence(text, start, i, j, k) {
+			boundaries = append(boundaries, k)
+		}
+		i = k
+	}
+	return boundaries
+}
+
+// continuesSentence reports whether the period run text[dot:afterDot] is an
+// abbreviation, an initial or otherwise followed by a lowercase word, in which
+// case the sentence goes on at next
+func continuesSentence(text string, start, dot, afterDot, next int) bool {
+	if text[dot] != '.' || strings.Count(text[dot:afterDot], ".") > 1 {
+		return false
+	}
+
+	// The word before the period, which may itself contain periods ("e.g")
+	wordStart := dot
+	for wordStart > start {
+		r, size := utf8.DecodeLastRuneInString(text[start:wordStart])
+		if !unicode.IsLetter(r) && r != '.' {
+			break
+		}
+		wordStart -= size
+	}
+	word := text[wordStart:dot]
+
+	if abbreviations[strings.ToLower(word)] {
+		return true
+	}
+	// Initials such as "J. R. R. Tolkien"
+	if utf8.RuneCountInString(word) == 1 {
+		if r, _ := utf8.DecodeRuneInString(word); unicode.IsUpper(r) {
+			return true
+		}
+	}
+
+	// A lowercase continuation means the period did not end the sentence
+	r, _ := utf8.DecodeRuneInString(text[next:])
+	return unicode.IsLower(r)
+}
+
+// wordBoundaries splits after whitespace
+func wordBoundaries(text string, start, end int) []int {
+	var boundaries []int
+	afterSpace := false
+	for i, r := range text[start:end] {
+		if unicode.IsSpace(r) {
+			afterSpace = true
+			continue
+		}
+		if afterSpace {
+			boundaries = append(boundaries, start+i)
+		}
+		afterSpace = false
+	}
+	return boundaries
+}
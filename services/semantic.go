@@ -0,0 +1,161 @@
+package services
+
+import (
+	"batch-embedding-api/models"
+	"context"
+	"math"
+	"sort"
+	"strings"
+)
+
+// semanticText is an input split into sentences for semantic chunking
+type semanticText struct {
+	measure   *unitMeasure
+	sentences []textSpan
+	window    int // index of the first sentence's window among all windows
+}
+
+// chunkSemantic splits the inputs longer than the chunk size where the topic
+// shifts. Every sentence is embedded together with its neighbours, and a
+// chunk ends after a sentence whose window is further from the next one than
+// the breakpoint percentile of all distances in its text, provided the chunk
+// is at least minSize long. Chunks never exceed the chunk size. The windows
+// of all inputs are embedded in one batch through the model's providers. It
+// returns the chunks of each input, nil for inputs that fit, and how the
+// windows were embedded.
+func (s *EmbeddingService) chunkSemantic(ctx context.Context, model *Model, inputs []models.InputItem, opts chunkOptions, embedOpts EmbedOptions) ([][]TextChunk, *embeddedTexts, error) {
+	// Chunks are whole sentences, so they do not overlap
+	opts.overlap = 0
+
+	texts := make([]*semanticText, len(inputs))
+	var windows []string
+	for i, input := range inputs {
+		if opts.length(input.Text) <= opts.size {
+			continue
+		}
+		measure := newUnitMeasure(input.Text, textUnits(input.Text, opts))
+		text := &semanticText{measure: measure, sentences: semanticSentences(measure, opts), window: len(windows)}
+		if len(text.sentences) > 1 {
+			windows = append(windows, sentenceWindows(measure, text.sentences, opts)...)
+		}
+		texts[i] = text
+	}
+
+	embedded := &embeddedTexts{}
+	if len(windows) > 0 {
+		var err error
+		embedded, err = s.embedTexts(ctx, model, windows, true, embedOpts, nil)
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+
+	chunksByInput := make([][]TextChunk, len(inputs))
+	for i, text := range texts {
+		if text == nil {
+			continue
+		}
+		var breakpoints []bool
+		if len(text.sentences) > 1 {
+			breakpoints = semanticBreakpoints(embedded.vectors[text.window:text.window+len(text.sentences)], opts.percentile)
+		}
+		chunksByInput[i] = groupSentences(inputs[i].ID, text.measure, text.sentences, breakpoints, opts)
+	}
+	return chunksByInput, embedded, nil
+}
+
+// semanticSentences splits a text into sentences, cutting those longer than
+// the chunk size further and dropping whitespace between paragraphs
+func semanticSentences(measure *unitMeasure, opts chunkOptions) []textSpan {
+	text := measure.text
+	var sentences []textSpan
+	for _, paragraph := range splitSpan(textSpan{0, len(text)}, paragraphBoundaries(text, 0, len(text))) {
+		for _, sentence := range splitSpan(paragraph, sentenceBoundaries(text, paragraph.start, paragraph.end)) {
+			if strings.TrimSpace(text[sentence.start:sentence.end]) == "" {
+				continue
+			}
+			if measure.length(sentence) > opts.size {
+				sentences = append(sentences, recursiveSpans(measure, sentence, opts)...)
+				continue
+			}
+			sentences = append(sentences, sentence)
+		}
+	}
+	return sentences
+}
+
+// sentenceWindows returns the text embedded for each sentence: the sentence
+// with up to opts.window sentences on either side, or the sentence alone when
+// that would exceed the model's input limit
+func sentenceWindows(measure *unitMeasure, sentences []textSpan, opts chunkOptions) []string {
+	windows := make([]string, len(sentences))
+	for i, sentence := range sentences {
+		first, last := i-opts.window, i+opts.window
+		if first < 0 {
+			first = 0
+		}
+		if last >= len(sentences) {
+			last = len(sentences) - 1
+		}
+
+		window := textSpan{sentences[first].start, sentences[last].end}
+		if measure.length(window) > opts.limit {
+			window = sentence
+		}
+		windows[i] = strings.TrimSpace(measure.text[window.start:window.end])
+	}
+	return windows
+}
+
+// semanticBreakpoints reports for each sentence but the last whether the
+// cosine distance between its window and the next exceeds the given
+// percentile of all such distances. The vectors must be normalized.
+func semanticBreakpoints(vectors [][]float32, percentile int) []bool {
+	distances := make([]float64, len(vectors)-1)
+	for i := range distances {
+		similarity := 0.0
+		for j, value := range vectors[i] {
+			similarity += float64(value) * float64(vectors[i+1][j])
+		}
+		distances[i] = 1 - similarity
+	}
+
+	// Linear interpolation between the closest ranks
+	sorted := append([]float64(nil), distances...)
+	sort.Float64s(sorted)
+	rank := float64(percentile) / 100 * float64(len(sorted)-1)
+	lower := int(math.Floor(rank))
+	upper := int(math.Ceil(rank))
+	threshold := sorted[lower] + (sorted[upper]-sorted[lower])*(rank-float64(lower))
+
+	breakpoints := make([]bool, len(distances))
+	for i, distance := range distances {
+		breakpoints[i] = distance > threshold
+	}
+	return breakpoints
+}
+
+// groupSentences packs consecutive sentences into chunks, ending a chunk at a
+// breakpoint once it reaches opts.minSize and before it would exceed
+// opts.size
+func groupSentences(docID string, measure *unitMeasure, sentences []textSpan, breakpoints []bool, opts chunkOptions) []TextChunk {
+	var chunks []TextChunk
+	start, length := 0, 0
+	for i, sentence := range sentences {
+		sentenceLength := measure.length(sentence)
+		if i > start && length+sentenceLength > opts.size {
+			chunks = appendChunk(chunks, docID, measure, textSpan{sentences[start].start, sentences[i-1].end})
+			start, length = i, 0
+		}
+		length += sentenceLength
+
+		if i < len(breakpoints) && breakpoints[i] && length >= opts.minSize {
+			chunks = appendChunk(chunks, docID, measure, textSpan{sentences[start].start, sentence.end})
+			start, length = i+1, 0
+		}
+	}
+	if start < len(sentences) {
+		chunks = appendChunk(chunks, docID, measure, textSpan{sentences[start].start, sentences[len(sentences)-1].end})
+	}
+	return chunks
+}
@@ -0,0 +1,299 @@
+package services
+
+import (
+	"bufio"
+	"encoding/base64"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Tokenizer kinds accepted in TOKENIZER and a model's tokenizer field
+const (
+	TokenizerWhitespace = "whitespace"
+	TokenizerBPE        = "bpe"
+)
+
+// Tokenizer splits text into the tokens an upstream model counts and bills
+type Tokenizer interface {
+	// Name identifies the tokenizer, e.g. "whitespace" or "cl100k_base"
+	Name() string
+	// Count returns the number of tokens in text
+	Count(text string) int
+	// Tokens returns the byte span of every token in text, in order
+	Tokens(text string) []TokenSpan
+}
+
+// TokenSpan is the byte range [Start, End) of a token within its text.
+// Byte-level BPE tokens may end inside a multi-byte character.
+type TokenSpan struct {
+	Start int
+	End   int
+}
+
+// NewTokenizer returns the tokenizer of the given kind. The bpe kind loads
+// its vocabulary from vocabPath, a tiktoken file of "base64-token rank" lines
+// such as cl100k_base.tiktoken.
+func NewTokenizer(kind, vocabPath string) (Tokenizer, error) {
+	switch kind {
+	case "", TokenizerWhitespace:
+		return WhitespaceTokenizer{}, nil
+	case TokenizerBPE:
+		if vocabPath == "" {
+			return nil, fmt.Errorf("the bpe tokenizer requires a vocabulary file (TOKENIZER_VOCAB_PATH)")
+		}
+		return LoadBPETokenizer(vocabPath)
+	default:
+		return nil, fmt.Errorf("unknown tokenizer: %s", kind)
+	}
+}
+
+// WhitespaceTokenizer approximates tokens as whitespace-separated words. It
+// needs no vocabulary but undercounts punctuation-heavy and non-Latin text.
+type WhitespaceTokenizer struct{}
+
+// Name returns the tokenizer name
+func (WhitespaceTokenizer) Name() string { return TokenizerWhitespace }
+
+// Count returns the number of words in text
+func (WhitespaceTokenizer) Count(text string) int {
+	return len(strings.Fields(text))
+}
+
+// Tokens returns the byte span of every word in text
+func (WhitespaceTokenizer) Tokens(text string) []TokenSpan {
+	var spans []TokenSpan
+	start := -1
+	for i, r := range text {
+		if unicode.IsSpace(r) {
+			if start >= 0 {
+				spans = append(spans, TokenSpan{Start: start, End: i})
+				start = -1
+			}
+		} else if start < 0 {
+			start = i
+		}
+	}
+	if start >= 0 {
+		spans = append(spans, TokenSpan{Start: start, End: len(text)})
+	}
+	return spans
+}
+
+// BPETokenizer is a byte-level BPE tokenizer compatible with tiktoken
+// vocabularies using the cl100k pre-tokenization rules
+type BPETokenizer struct {
+	name  string
+	ranks map[string]int
+}
+
+// LoadBPETokenizer reads a tiktoken vocabulary file
+func LoadBPETokenizer(path string) (*BPETokenizer, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open tokenizer vocabulary: %w", err)
+	}
+	defer file.Close()
+
+	ranks := make(map[string]int)
+	scanner := bufio.NewScanner(file)
+	lineNumber := 0
+	for scanner.Scan() {
+		lineNumber++
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+
+		encoded, rankText, found := strings.Cut(line, " ")
+		if !found {
+			return nil, fmt.Errorf("tokenizer vocabulary %s line %d: expected \"token rank\"", path, lineNumber)
+		}
+		token, err := base64.StdEncoding.DecodeString(encoded)
+		if err != nil {
+			return nil, fmt.Errorf("tokenizer vocabulary %s line %d: %w", path, lineNumber, err)
+		}
+		rank, err := strconv.Atoi(rankText)
+		if err != nil {
+			return nil, fmt.Errorf("tokenizer vocabulary %s line %d: %w", path, lineNumber, err)
+		}
+		ranks[string(token)] = rank
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read tokenizer vocabulary: %w", err)
+	}
+
+	// Every byte must be a token so that any text can be encoded
+	for b := 0; b < 256; b++ {
+		if _, exists := ranks[string([]byte{byte(b)})]; !exists {
+			return nil, fmt.Errorf("tokenizer vocabulary %s has no token for byte 0x%02x", path, b)
+		}
+	}
+
+	name := strings.TrimSuffix(filepath.Base(path), filepath.Ext(path))
+	return &BPETokenizer{name: name, ranks: ranks}, nil
+}
+
+// Name returns the vocabulary name, e.g. "cl100k_base"
+func (t *BPETokenizer) Name() string { return t.name }
+
+// Count returns the number of tokens in text
+func (t *BPETokenizer) Count(text string) int {
+	count := 0
+	for _, piece := range pretokenize(text) {
+		if _, exists := t.ranks[text[piece.Start:piece.End]]; exists {
+			count++
+			continue
+		}
+		count += len(t.mergePiece(text[piece.Start:piece.End])) - 1
+	}
+	return count
+}
+
+// Tokens returns the byte span of every token in text
+func (t *BPETokenizer) Tokens(text string) []TokenSpan {
+	var spans []TokenSpan
+	for _, piece := range pretokenize(text) {
+		if _, exists := t.ranks[text[piece.Start:piece.End]]; exists {
+			spans = append(spans, piece)
+			continue
+		}
+		boundaries := t.mergePiece(text[piece.Start:piece.End])
+		for i := 0; i+1 < len(boundaries); i++ {
+			spans = append(spans, TokenSpan{Start: piece.Start + boundaries[i], End: piece.Start + boundaries[i+1]})
+		}
+	}
+	return spans
+}
+
+// mergePiece applies byte-pair merges to piece, always merging the adjacent
+// pair with the lowest rank, and returns the token boundaries
+func (t *BPETokenizer) mergePiece(piece string) []int {
+	boundaries := make([]int, len(piece)+1)
+	for i := range boundaries {
+		boundaries[i] = i
+	}
+
+	for len(boundaries) > 2 {
+		bestRank, bestIndex := -1, -1
+		for i := 0; i+2 < len(boundaries); i++ {
+			rank, exists := t.ranks[piece[boundaries[i]:boundaries[i+2]]]
+			if exists && (bestRank < 0 || rank < bestRank) {
+				bestRank, bestIndex = rank, i
+			}
+		}
+		if bestIndex < 0 {
+			break
+		}
+		boundaries = append(boundaries[:bestIndex+1], boundaries[bestIndex+2:]...)
+	}
+	return boundaries
+}
+
+// pretokenize splits text into the pieces BPE merges are confined to. It
+// follows the cl100k pattern:
+//
+//	(?i:'s|'t|'re|'ve|'m|'ll|'d)|[^\r\n\p{L}\p{N}]?\p{L}+|\p{N}{1,3}|
+//	 ?[^\s\p{L}\p{N}]+[\r\n]*|\s*[\r\n]+|\s+(?!\S)|\s+
+//
+// which Go's regexp package cannot express because of the lookahead.
+func pretokenize(text string) []TokenSpan {
+	var pieces []TokenSpan
+	for start := 0; start < len(text); {
+		end := matchPiece(text, start)
+		pieces = append(pieces, TokenSpan{Start: start, End: end})
+		start = end
+	}
+	return pieces
+}
+
+// contractions are the suffixes split off as their own piece, matched
+// case-insensitively after an apostrophe
+var contractions = []string{"s", "t", "re", "ve", "m", "ll", "d"}
+
+// matchPiece returns the end of the piece starting at start
+func matchPiece(text string, start int) int {
+	r, size := utf8.DecodeRuneInString(text[start:])
+	next, _ := utf8.DecodeRuneInString(text[start+size:])
+	hasNext := start+size < len(text)
+
+	// Contractions
+	if r == '\'' {
+		rest := text[start+size:]
+		for _, suffix := range contractions {
+			if len(rest) >= len(suffix) && strings.EqualFold(rest[:len(suffix)], suffix) {
+				return start + size + len(suffix)
+			}
+		}
+	}
+
+	// Words, optionally led by one non-letter such as a space
+	if unicode.IsLetter(r) {
+		return scanWhile(text, start, unicode.IsLetter)
+	}
+	if r != '\r' && r != '\n' && !unicode.IsNumber(r) && hasNext && unicode.IsLetter(next) {
+		return scanWhile(text, start+size, unicode.IsLetter)
+	}
+
+	// Up to three digits
+	if unicode.IsNumber(r) {
+		end := start + size
+		for i := 1; i < 3 && end < len(text); i++ {
+			digit, digitSize := utf8.DecodeRuneInString(text[end:])
+			if !unicode.IsNumber(digit) {
+				break
+			}
+			end += digitSize
+		}
+		return end
+	}
+
+	// Punctuation runs, optionally led by a space and followed by newlines
+	punctStart := start
+	if r == ' ' && hasNext && isPunctuation(next) {
+		punctStart = start + size
+	}
+	if first, _ := utf8.DecodeRuneInString(text[punctStart:]); isPunctuation(first) {
+		end := scanWhile(text, punctStart, isPunctuation)
+		return scanWhile(text, end, func(r rune) bool { return r == '\r' || r == '\n' })
+	}
+
+	// Whitespace: up to the last newline of the run, otherwise the run
+	// minus its last character when a word follows
+	end := scanWhile(text, start, unicode.IsSpace)
+	if lastNewline := strings.LastIndexAny(text[start:end], "\r\n"); lastNewline >= 0 {
+		return start + lastNewline + 1
+	}
+	if end < len(text) {
+		_, lastSize := utf8.DecodeLastRuneInString(text[start:end])
+		if end-lastSize > start {
+			return end - lastSize
+		}
+	}
+	if end > start {
+		return end
+	}
+	return start + size
+}
+
+// scanWhile returns the end of the run of runes matching match at start
+func scanWhile(text string, start int, match func(rune) bool) int {
+	end := start
+	for end < len(text) {
+		r, size := utf8.DecodeRuneInString(text[end:])
+		if !match(r) {
+			break
+		}
+		end += size
+	}
+	return end
+}
+
+// isPunctuation matches [^\s\p{L}\p{N}]
+func isPunctuation(r rune) bool {
+	return !unicode.IsSpace(r) && !unicode.IsLetter(r) && !unicode.IsNumber(r)
+}
@@ -4,7 +4,9 @@ import (
 	"batch-embedding-api/config"
 	"batch-embedding-api/models"
 	"bytes"
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"log"
@@ -23,16 +25,21 @@ type Worker struct {
 	jobQueue         chan string
 	wg               sync.WaitGroup
 	stopCh           chan struct{}
+	ctx              context.Context // cancelled by Stop to abort in-flight jobs
+	cancel           context.CancelFunc
 }
 
 // NewWorker creates a new background worker
 func NewWorker(cfg *config.Config, jobStore *JobStore, embeddingService *EmbeddingService) *Worker {
+	ctx, cancel := context.WithCancel(context.Background())
 	return &Worker{
 		config:           cfg,
 		jobStore:         jobStore,
 		embeddingService: embeddingService,
 		jobQueue:         make(chan string, 100),
 		stopCh:           make(chan struct{}),
+		ctx:              ctx,
+		cancel:           cancel,
 	}
 }
 
@@ -45,9 +52,10 @@ func (w *Worker) Start(numWorkers int) {
 	log.Printf("Started %d background workers", numWorkers)
 }
 
-// Stop gracefully stops the worker
+// Stop stops the worker, cancelling jobs that are still running
 func (w *Worker) Stop() {
 	close(w.stopCh)
+	w.cancel()
 	w.wg.Wait()
 	log.Println("All workers stopped")
 }
@@ -65,18 +73,21 @@ func (w *Worker) processLoop(workerID int) {
 		case <-w.stopCh:
 			return
 		case jobID := <-w.jobQueue:
-			w.processJob(workerID, jobID)
+			w.processJob(w.ctx, workerID, jobID)
 		}
 	}
 }
 
-func (w *Worker) processJob(workerID int, jobID string) {
+func (w *Worker) processJob(ctx context.Context, workerID int, jobID string) {
 	job := w.jobStore.GetJob(jobID)
 	if job == nil {
 		log.Printf("[Worker %d] Job %s not found", workerID, jobID)
 		return
 	}
 
+	ctx, cancel := context.WithTimeout(ctx, w.jobTimeout(job))
+	defer cancel()
+
 	log.Printf("[Worker %d] Processing job %s", workerID, jobID)
 
 	// Update status to running
@@ -84,53 +95,80 @@ func (w *Worker) processJob(workerID int, jobID string) {
 	job.Progress = 0
 	w.jobStore.UpdateJob(job)
 
-	// Process each file
-	results := make([]models.EmbedResponse, 0)
+	// Embed one file at a time so only the current file's text is held in memory
+	results := make([]models.EmbedResponse, 0, len(job.Files))
 	totalFiles := len(job.Files)
+	normalize := true
+	if job.Normalize != nil {
+		normalize = *job.Normalize
+	}
+	// Texts repeated across files are embedded once for the whole job
+	vectors := newJobVectors()
 
 	for i, fileURL := range job.Files {
 		// Download file
-		content, filename, err := w.downloadFile(fileURL)
+		content, filename, err := w.downloadFile(ctx, fileURL)
 		if err != nil {
 			log.Printf("[Worker %d] Error downloading %s: %v", workerID, fileURL, err)
 			job.Status = "failed"
-			job.Error = &models.Error{Code: "download_failed", Message: err.Error()}
+			job.Error = &models.Error{Code: jobErrorCode(err, "download_failed"), Message: err.Error()}
 			w.jobStore.UpdateJob(job)
 			w.sendCallback(job)
 			return
 		}
 
 		// Extract text
-		text, err := w.embeddingService.ExtractTextFromFile(filename, content)
+		extracted, err := w.embeddingService.ExtractTextFromFile(ctx, filename, content)
 		if err != nil {
 			log.Printf("[Worker %d] Error extracting text from %s: %v", workerID, filename, err)
 			job.Status = "failed"
-			job.Error = &models.Error{Code: "extraction_failed", Message: err.Error()}
+			job.Error = &models.Error{Code: jobErrorCode(err, "extraction_failed"), Message: err.Error()}
 			w.jobStore.UpdateJob(job)
 			w.sendCallback(job)
 			return
 		}
 
+		// Without a job-wide strategy each file gets the default of its type
+		truncateStrategy := job.TruncateStrategy
+		if truncateStrategy == "" {
+			truncateStrategy = TruncateStrategyForFile(filename, extracted.Language)
+		}
+
 		// Generate embeddings
 		req := &models.EmbedRequest{
-			Model:            job.Model,
-			Inputs:           []models.InputItem{{ID: filename, Text: text}},
-			TruncateStrategy: "split",
-			ChunkSize:        w.config.DefaultChunkSize,
-			Normalize:        true,
+			Model: job.Model,
+			Inputs: []models.InputItem{{
+				ID:       filename,
+				Text:     extracted.Text,
+				Language: extracted.Language,
+				Source:   fileURL,
+				Pages:    extracted.Pages,
+			}},
+			TruncateStrategy:     truncateStrategy,
+			ChunkSize:            job.ChunkSize,
+			ChunkUnit:            job.ChunkUnit,
+			ChunkOverlap:         job.ChunkOverlap,
+			MinChunkSize:         job.MinChunkSize,
+			BreakpointPercentile: job.BreakpointPercentile,
+			SplitAtPages:         job.SplitAtPages,
+			PrependHeadingPath:   job.PrependHeadingPath,
+			Normalize:            &normalize,
+			InputType:            job.InputType,
 		}
 
-		resp, err := w.embeddingService.GenerateEmbeddings(req)
+		resp, err := w.embeddingService.generateEmbeddings(ctx, req, vectors)
 		if err != nil {
 			log.Printf("[Worker %d] Error generating embeddings for %s: %v", workerID, filename, err)
 			job.Status = "failed"
-			job.Error = &models.Error{Code: "embedding_failed", Message: err.Error()}
+			job.Error = &models.Error{Code: jobErrorCode(err, "embedding_failed"), Message: err.Error()}
 			w.jobStore.UpdateJob(job)
 			w.sendCallback(job)
 			return
 		}
 
-		results = append(results, *resp)
+		results = append(results, models.EmbedResponse{Results: resp.Results})
+		job.Dedup = vectors.dedup()
+		job.Usage = addUsage(job.Usage, resp.Usage)
 
 		// Update progress
 		job.Progress = ((i + 1) * 100) / totalFiles
@@ -160,7 +198,7 @@ func (w *Worker) processJob(workerID int, jobID string) {
 	w.sendCallback(job)
 }
 
-func (w *Worker) downloadFile(url string) ([]byte, string, error) {
+func (w *Worker) downloadFile(ctx context.Context, url string) ([]byte, string, error) {
 	// Check if it's a local file path
 	if _, err := os.Stat(url); err == nil {
 		content, err := os.ReadFile(url)
@@ -171,8 +209,13 @@ func (w *Worker) downloadFile(url string) ([]byte, string, error) {
 	}
 
 	// Download from URL
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, "", err
+	}
+
 	client := &http.Client{Timeout: 60 * time.Second}
-	resp, err := client.Get(url)
+	resp, err := client.Do(req)
 	if err != nil {
 		return nil, "", err
 	}
@@ -251,3 +294,50 @@ func (w *Worker) sendCallback(job *models.Job) {
 
 	log.Printf("Callback sent to %s: status %d", job.CallbackURL, resp.StatusCode)
 }
+
+// jobTimeout returns the deadline for a job: its requested timeout capped
+// at JOB_TIMEOUT_SECONDS
+func (w *Worker) jobTimeout(job *models.Job) time.Duration {
+	maxTimeout := time.Duration(w.config.JobTimeoutSeconds) * time.Second
+	if job.TimeoutMs > 0 {
+		if timeout := time.Duration(job.TimeoutMs) * time.Millisecond; timeout < maxTimeout {
+			return timeout
+		}
+	}
+	return maxTimeout
+}
+
+// jobErrorCode returns the job error code for a failure, using defaultCode
+// when the error carries no more specific classification
+func jobErrorCode(err error, defaultCode string) string {
+	switch {
+	case errors.Is(err, context.DeadlineExceeded):
+		return "timeout"
+	case errors.Is(err, context.Canceled):
+		return "cancelled"
+	case errors.Is(err, ErrUnknownModel):
+		return "invalid_request"
+	case errors.Is(err, ErrInputTooLong):
+		return "input_too_long"
+	}
+
+	var providerErr *ProviderError
+	if errors.As(err, &providerErr) {
+		return providerErr.Code
+	}
+	return ExtractionErrorCode(err, defaultCode)
+}
+
+// addUsage adds the usage of one file to a job's total
+func addUsage(total, file *models.Usage) *models.Usage {
+	if file == nil {
+		return total
+	}
+	if total == nil {
+		total = &models.Usage{}
+	}
+	total.PromptTokens += file.PromptTokens
+	total.Chunks += file.Chunks
+	total.UpstreamCalls += file.UpstreamCalls
+	return total
+}